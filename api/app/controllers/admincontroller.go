@@ -1,19 +1,20 @@
 package controllers
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"api/app/db"
 	"api/app/models"
 
 	"github.com/revel/revel"
-	"github.com/xuri/excelize/v2"
 	"golang.org/x/crypto/bcrypt"
 
 	"fmt"
@@ -22,7 +23,9 @@ import (
 
 type AdminController struct {
 	*revel.Controller
-	DB *sql.DB
+	DB       *sql.DB
+	Username string
+	Role     string
 }
 
 func (c *AdminController) SetDB() revel.Result {
@@ -38,7 +41,6 @@ func (c *AdminController) SetDB() revel.Result {
 //--------------------------------------------------------------------//
 
 func (c *AdminController) Before() revel.Result {
-	fmt.Println(">>> Before filter is running")
 	tokenString := c.Request.Header.Get("Authorization")
 
 	if tokenString == "" {
@@ -49,59 +51,59 @@ func (c *AdminController) Before() revel.Result {
 		tokenString = tokenString[7:]
 	}
 
-	_, err := db.ValidateJWT(tokenString)
+	username, role, err := db.AdminFromJWT(tokenString)
 	if err != nil {
 		return c.Forbidden("Invalid or expired token")
 	}
+	c.Username = username
+	c.Role = role
 
 	return nil
 }
 
-func (c *AdminController) ResetElections() revel.Result {
-	_, err := c.DB.Exec("SET FOREIGN_KEY_CHECKS = 0;")
-	if err != nil {
-		revel.AppLog.Errorf("Failed to disable foreign key checks: %v", err)
-		return c.RenderJSON(map[string]string{"error": "Failed to disable foreign key checks"})
+// refuseIfEmbargoed blocks live counts from admin roles the results embargo applies to
+func (c *AdminController) refuseIfEmbargoed() revel.Result {
+	if db.ActiveEmbargo(c.Role) != db.EmbargoOff {
+		c.Response.Status = http.StatusForbidden
+		return c.RenderJSON(map[string]interface{}{
+			"error":     "Results are embargoed until polls close",
+			"embargoed": true,
+		})
 	}
+	return nil
+}
 
-	tables := []string{"votes", "candidates", "voters", "election_settings"}
-	for _, table := range tables {
-		_, err := c.DB.Exec("TRUNCATE TABLE " + table + ";")
-		if err != nil {
-			revel.AppLog.Errorf("Failed to truncate table %s: %v", table, err)
-			return c.RenderJSON(map[string]string{"error": "Failed to delete data from " + table})
-		}
+// refuseIfCertified blocks changes that would alter certified results
+func (c *AdminController) refuseIfCertified() revel.Result {
+	if db.ElectionCertified() {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]string{"error": "Election results are certified and can no longer be changed"})
 	}
+	return nil
+}
 
-	_, err = c.DB.Exec("SET FOREIGN_KEY_CHECKS = 1;")
-	if err != nil {
-		revel.AppLog.Errorf("Failed to enable foreign key checks: %v", err)
-		return c.RenderJSON(map[string]string{"error": "Failed to enable foreign key checks"})
+// ResetElections starts a new election: the current election's data is deleted, certified or not, and
+// the signed canvass records of past elections are kept
+func (c *AdminController) ResetElections() revel.Result {
+	err := db.StartNewElection(c.Username)
+	if err == db.ErrElectionOpen {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]string{"error": "Voting is under way; close the election before starting a new one"})
 	}
-
-	uploadsFolder := filepath.Join(revel.BasePath, "..", "web", "public", "uploads")
-
-	err = filepath.Walk(uploadsFolder, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			if removeErr := os.Remove(path); removeErr != nil {
-				revel.AppLog.Errorf("Failed to delete file %s: %v", path, removeErr)
-			}
-		}
-		return nil
-	})
-
 	if err != nil {
-		revel.AppLog.Errorf("Failed to clean uploads folder: %v", err)
-		return c.RenderJSON(map[string]string{"error": "Failed to clean uploads folder"})
+		revel.AppLog.Errorf("Failed to start a new election: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to delete election data"})
 	}
 
 	return c.RenderJSON(map[string]string{"success": "All data deleted successfully"})
 }
 
 func (c *AdminController) PostVotingTimeframe() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
 	var request models.Timeframe
 	if err := c.Params.BindJSON(&request); err != nil {
 		c.Response.Status = http.StatusBadRequest
@@ -132,11 +134,6 @@ func (c *AdminController) PostVotingTimeframe() revel.Result {
 	formattedStart := startTime.Format("2006-01-02 15:04:05")
 	formattedEnd := endTime.Format("2006-01-02 15:04:05")
 
-	fmt.Println("Current:", currentTime)
-	fmt.Println("Start:", startTime)
-	fmt.Println("End:", endTime)
-	fmt.Println("isActiveInt:", isActiveInt)
-
 	query := `INSERT INTO election_settings (id, voting_start, voting_end, is_active)
 			  VALUES (1, ?, ?, ?)
 			  ON DUPLICATE KEY UPDATE
@@ -204,54 +201,101 @@ func (c *AdminController) FirstPassword() revel.Result {
 }
 
 func (c *AdminController) PostCandidates() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
 	var payload models.PostCandidatesPayload
 	if err := c.Params.BindJSON(&payload); err != nil {
 		c.Response.Status = http.StatusBadRequest
 		return c.RenderJSON(map[string]string{"error": "Invalid request payload"})
 	}
 
-	sections := map[string][]models.Candidate{
-		"Governor":          payload.GovernorCandidates,
-		"Vice Governor":     payload.ViceGovernorCandidates,
-		"BEED":              payload.BeedCandidates,
-		"BPED/BTLED/BTVDED": payload.BpedBtledBtvdedCandidates,
-		"BIT":               payload.BitCandidates,
-		"BSIT":              payload.BsitCandidates,
-		"BSED":              payload.BsedCandidates,
-		"CBA":               payload.CbaCandidates,
-		"COE":               payload.CoeCandidates,
+	// Sections are listed in a fixed order so reported indexes are stable
+	sections := []struct {
+		Name       string
+		Candidates []models.Candidate
+	}{
+		{"governorCandidates", payload.GovernorCandidates},
+		{"viceGovernorCandidates", payload.ViceGovernorCandidates},
+		{"beedCandidates", payload.BeedCandidates},
+		{"bpedBtledBtvdedCandidates", payload.BpedBtledBtvdedCandidates},
+		{"bitCandidates", payload.BitCandidates},
+		{"bsitCandidates", payload.BsitCandidates},
+		{"bsedCandidates", payload.BsedCandidates},
+		{"cbaCandidates", payload.CbaCandidates},
+		{"coeCandidates", payload.CoeCandidates},
 	}
 
-	insertQuery := `INSERT INTO candidates 
-		(position_name, name, position, year_level, program, partylist)
-		VALUES (?, ?, ?, ?, ?, ?)`
+	var submissions []models.CandidateSubmission
+	for _, section := range sections {
+		for i, candidate := range section.Candidates {
+			submissions = append(submissions, models.CandidateSubmission{Section: section.Name, Index: i, Candidate: candidate})
+		}
+	}
 
-	for _, candidates := range sections {
-		for _, candidate := range candidates {
-			positionName := candidate.Position + "_" + candidate.Name
-			_, err := c.DB.Exec(insertQuery,
-				positionName,
-				candidate.Name,
-				candidate.Position,
-				candidate.YearLevel,
-				candidate.Program,
-				candidate.Partylist,
-			)
-			if err != nil {
-				revel.AppLog.Errorf("Failed to save candidate %s: %v", candidate.Name, err)
-				c.Response.Status = http.StatusInternalServerError
-				return c.RenderJSON(map[string]string{"message": "Failed to save candidates"})
-			}
+	// Partylists sent with the payload, including the legacy left/right pair, are created or updated along with the candidates
+	partylists := payload.Partylists
+	for _, name := range []string{payload.PartylistLeft, payload.PartylistRight} {
+		if name != "" {
+			partylists = append(partylists, models.Partylist{Name: name})
+		}
+	}
+	for _, partylist := range partylists {
+		if err := db.ValidatePartylist(partylist); err != nil {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJSON(map[string]string{"error": err.Error()})
 		}
 	}
 
-	return c.RenderJSON(map[string]string{"message": "Candidates saved successfully"})
+	issues, err := db.ValidateCandidates(submissions, payload.Upsert)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to validate candidates: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"message": "Failed to save candidates"})
+	}
+	if len(issues) > 0 {
+		c.Response.Status = http.StatusUnprocessableEntity
+		return c.RenderJSON(map[string]interface{}{
+			"error":  "Some candidates are invalid; nothing was saved",
+			"issues": issues,
+		})
+	}
+
+	result, err := db.SaveCandidates(partylists, submissions, payload.Upsert, c.Username)
+	if errors.Is(err, db.ErrUnknownPartylist) {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": err.Error() + "; add it to the submitted partylists first"})
+	}
+	if err != nil {
+		revel.AppLog.Errorf("Failed to save candidates: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"message": "Failed to save candidates"})
+	}
+
+	return c.RenderJSON(map[string]interface{}{
+		"message": "Candidates saved successfully",
+		"created": result.Created,
+		"updated": result.Updated,
+	})
 }
 
 func (c *AdminController) UploadCandidatePhoto() revel.Result {
-	position := c.Params.Get("position")
-	name := c.Params.Get("name")
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	// Photos are keyed by candidate ID; older clients send position_name, or position and name
+	candidateID, _ := strconv.ParseInt(c.Params.Get("candidate_id"), 10, 64)
 	positionName := c.Params.Get("position_name")
+	if candidateID == 0 && positionName == "" {
+		positionName = c.Params.Get("position") + "_" + c.Params.Get("name")
+	}
+	candidateID, err := db.ResolveCandidateID(candidateID, positionName)
+	if err != nil {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Candidate not found"})
+	}
 
 	files := c.Params.Files["photo"]
 	if len(files) == 0 {
@@ -260,86 +304,81 @@ func (c *AdminController) UploadCandidatePhoto() revel.Result {
 	}
 
 	fileHeader := files[0]
-	ext := filepath.Ext(fileHeader.Filename)
+	if fileHeader.Size > db.MaxPhotoBytes() {
+		c.Response.Status = http.StatusRequestEntityTooLarge
+		return c.RenderJSON(map[string]string{"error": "Photo is too large"})
+	}
 
-	if positionName != "" {
-		url := positionName + ext
-		_, err := c.DB.Exec(`UPDATE candidates SET photo_url = ? WHERE position_name = ?`, url, positionName)
-		if err != nil {
-			return c.RenderJSON(map[string]string{"message": "Failed to update photo_url in DB"})
-		}
+	file, err := fileHeader.Open()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to open file: %v", err)
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Failed to open photo file"})
+	}
+	defer file.Close()
 
-		file, err := fileHeader.Open()
-		if err != nil {
-			revel.AppLog.Errorf("Failed to open file: %v", err)
-			c.Response.Status = http.StatusBadRequest
-			return c.RenderJSON(map[string]string{"error": "Failed to open photo file"})
+	// The upload's name and extension are never used; files are named after their re-encoded content
+	photo, err := db.ProcessPhoto(file)
+	if err != nil {
+		if errors.Is(err, db.ErrPhotoTooLarge) {
+			c.Response.Status = http.StatusRequestEntityTooLarge
+			return c.RenderJSON(map[string]string{"error": "Photo is too large"})
 		}
-		defer file.Close()
-
-		baseDir := filepath.Join(revel.BasePath, "..", "web", "public", "uploads")
-		revel.AppLog.Infof("Uploads directory: %s", baseDir)
-
-		destinationPath := filepath.Join(baseDir, positionName+filepath.Ext(fileHeader.Filename))
-		revel.AppLog.Infof("Saving file to: %s", destinationPath)
-		destFile, err := os.Create(destinationPath)
-		if err != nil {
-			revel.AppLog.Errorf("Failed to create file: %v", err)
-			c.Response.Status = http.StatusInternalServerError
-			return c.RenderJSON(map[string]string{"error": "Failed to save photo"})
+		if errors.Is(err, db.ErrPhotoRejected) {
+			c.Response.Status = http.StatusUnsupportedMediaType
+			return c.RenderJSON(map[string]string{"error": err.Error()})
 		}
-		defer destFile.Close()
+		revel.AppLog.Errorf("Failed to process photo for candidate %d: %v", candidateID, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to save photo"})
+	}
 
-		_, err = io.Copy(destFile, file)
-		if err != nil {
-			revel.AppLog.Errorf("Failed to save file: %v", err)
+	for filename, data := range map[string][]byte{photo.Filename: photo.Image, photo.ThumbnailFilename: photo.Thumbnail} {
+		if err := db.PutBytes(db.Photos, filename, data, "image/jpeg"); err != nil {
+			revel.AppLog.Errorf("Failed to save file %s: %v", filename, err)
+			removeUnusedPhotos(photo.Filename, photo.ThumbnailFilename)
 			c.Response.Status = http.StatusInternalServerError
 			return c.RenderJSON(map[string]string{"error": "Failed to save photo"})
 		}
-	} else {
-		filename := position + "_" + name
-		url := position + "_" + name + ext
-		_, err := c.DB.Exec(`UPDATE candidates SET photo_url = ? WHERE position_name = ?`, url, filename)
-		if err != nil {
-			return c.RenderJSON(map[string]string{"message": "Failed to update photo_url in DB"})
-		}
-
-		file, err := fileHeader.Open()
-		if err != nil {
-			revel.AppLog.Errorf("Failed to open file: %v", err)
-			c.Response.Status = http.StatusBadRequest
-			return c.RenderJSON(map[string]string{"error": "Failed to open photo file"})
-		}
-		defer file.Close()
-
-		baseDir := filepath.Join(revel.BasePath, "..", "web", "public", "uploads")
-		revel.AppLog.Infof("Uploads directory: %s", baseDir)
+	}
 
-		destinationPath := filepath.Join(baseDir, filename+filepath.Ext(fileHeader.Filename))
-		revel.AppLog.Infof("Saving file to: %s", destinationPath)
-		destFile, err := os.Create(destinationPath)
-		if err != nil {
-			revel.AppLog.Errorf("Failed to create file: %v", err)
-			c.Response.Status = http.StatusInternalServerError
-			return c.RenderJSON(map[string]string{"error": "Failed to save photo"})
-		}
-		defer destFile.Close()
+	// Files just written are removed again if the candidate cannot be pointed at them, unless another
+	// candidate already uses the same photo
+	var previousPhoto, previousThumbnail sql.NullString
+	err = c.DB.QueryRow(`SELECT photo_url, thumbnail_url FROM candidates WHERE id = ?`, candidateID).Scan(&previousPhoto, &previousThumbnail)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch current photo for candidate %d: %v", candidateID, err)
+		removeUnusedPhotos(photo.Filename, photo.ThumbnailFilename)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to update photo_url in DB"})
+	}
 
-		_, err = io.Copy(destFile, file)
-		if err != nil {
-			revel.AppLog.Errorf("Failed to save file: %v", err)
-			c.Response.Status = http.StatusInternalServerError
-			return c.RenderJSON(map[string]string{"error": "Failed to save photo"})
-		}
+	_, err = c.DB.Exec(`UPDATE candidates SET photo_url = ?, thumbnail_url = ? WHERE id = ?`, photo.Filename, photo.ThumbnailFilename, candidateID)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to update photo for candidate %d: %v", candidateID, err)
+		removeUnusedPhotos(photo.Filename, photo.ThumbnailFilename)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to update photo_url in DB"})
 	}
 
-	return c.RenderJSON(map[string]string{"message": "Photo uploaded successfully"})
+	removeUnusedPhotos(previousPhoto.String, previousThumbnail.String)
+
+	return c.RenderJSON(map[string]string{
+		"message":       "Photo uploaded successfully",
+		"photo_url":     photo.Filename,
+		"thumbnail_url": photo.ThumbnailFilename,
+	})
 }
 
 func (c *AdminController) PostCredentials() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
 	var candidates []struct {
-		PositionName string   `json:"position_name"`
-		Credentials  []string `json:"credentials"`
+		ID           int64               `json:"id"`
+		PositionName string              `json:"position_name"`
+		Credentials  []models.Credential `json:"credentials"`
 	}
 
 	if err := c.Params.BindJSON(&candidates); err != nil {
@@ -348,6 +387,18 @@ func (c *AdminController) PostCredentials() revel.Result {
 		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
 	}
 
+	// Every candidate's credentials are checked before any are written
+	for i := range candidates {
+		credentials, err := db.NormalizeCredentials(candidates[i].Credentials)
+		if err != nil {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJSON(map[string]string{
+				"error": fmt.Sprintf("Invalid credentials for %s: %v", candidates[i].PositionName, err),
+			})
+		}
+		candidates[i].Credentials = credentials
+	}
+
 	for _, candidate := range candidates {
 		credentialsJSON, err := json.Marshal(candidate.Credentials)
 		if err != nil {
@@ -357,13 +408,21 @@ func (c *AdminController) PostCredentials() revel.Result {
 			})
 		}
 
+		candidateID, err := db.ResolveCandidateID(candidate.ID, candidate.PositionName)
+		if err != nil {
+			revel.AppLog.Errorf("Failed to find candidate %d (%s): %v", candidate.ID, candidate.PositionName, err)
+			return c.RenderJSON(map[string]string{
+				"error": "Failed to update credentials for " + candidate.PositionName,
+			})
+		}
+
 		query := `
 			UPDATE candidates 
 			SET credentials = ?
-			WHERE position_name = ?
+			WHERE id = ?
 		`
 
-		_, err = c.DB.Exec(query, string(credentialsJSON), candidate.PositionName)
+		_, err = c.DB.Exec(query, string(credentialsJSON), candidateID)
 		if err != nil {
 			revel.AppLog.Errorf("Failed to update credentials for %s: %v", candidate.PositionName, err)
 			return c.RenderJSON(map[string]string{
@@ -377,128 +436,271 @@ func (c *AdminController) PostCredentials() revel.Result {
 
 // GetBackupList - List all backup files
 func (c AdminController) GetBackupList() revel.Result {
-	files, err := os.ReadDir("backup")
+	backups, err := db.ListBackups()
 	if err != nil {
+		revel.AppLog.Errorf("Failed to list backups: %v", err)
 		return c.RenderJSON(map[string]string{"error": "Failed to read backup folder"})
 	}
 
-	var backupList []map[string]string
-	for _, file := range files {
-		if !file.IsDir() {
-			backupList = append(backupList, map[string]string{
-				"filename":    file.Name(),
-				"downloadUrl": "/backup/" + file.Name(),
-			})
+	return c.RenderJSON(backups)
+}
+
+// GetBackupDownloadLink returns a short-lived signed link to download a backup
+func (c AdminController) GetBackupDownloadLink(filename string) revel.Result {
+	if !db.BackupExists(filename) {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Backup not found"})
+	}
+
+	downloadURL, expiresAt := db.BackupDownloadURL(filename)
+	return c.RenderJSON(map[string]string{
+		"downloadUrl": downloadURL,
+		"expires_at":  expiresAt.Format(time.RFC3339),
+	})
+}
+
+// VerifyBackup checks a stored backup against its manifest to detect modified or truncated files
+func (c AdminController) VerifyBackup(filename string) revel.Result {
+	verification, err := db.VerifyBackup(filename)
+	if err != nil {
+		if err != db.ErrObjectNotFound {
+			revel.AppLog.Errorf("Failed to verify backup %s: %v", filename, err)
+			c.Response.Status = http.StatusInternalServerError
+			return c.RenderJSON(map[string]string{"error": "Failed to read backup"})
 		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Backup not found"})
 	}
 
-	return c.RenderJSON(backupList)
+	return c.RenderJSON(verification)
 }
 
-func (c AdminController) GenerateBackup() revel.Result {
-	year := time.Now().Year()
-	nextYear := year + 1
-	currentTime := time.Now().Format("January 02 15-04")
-	filename := fmt.Sprintf("A.Y. %d-%d %s.xlsx", year, nextYear, currentTime)
-	filePath := filepath.Join("backup", filename)
+// EncryptBackup encrypts a backup stored before backups were always encrypted
+func (c AdminController) EncryptBackup(filename string) revel.Result {
+	err := db.EncryptStoredBackup(filename)
+	switch err {
+	case nil:
+	case db.ErrObjectNotFound:
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Backup not found"})
+	case db.ErrBackupEncrypted:
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]string{"error": "Backup is already encrypted"})
+	case db.ErrBackupKeyNotSet:
+		c.Response.Status = http.StatusServiceUnavailable
+		return c.RenderJSON(map[string]string{"error": "BACKUP_ENCRYPTION_KEY is not set"})
+	default:
+		revel.AppLog.Errorf("Failed to encrypt backup %s: %v", filename, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to encrypt backup"})
+	}
+
+	if err := db.RecordAudit(c.DB, c.Username, "backup.encrypt", filename, nil); err != nil {
+		revel.AppLog.Errorf("Failed to record audit entry: %v", err)
+	}
+	return c.RenderJSON(map[string]string{"message": "Backup encrypted"})
+}
 
-	f := excelize.NewFile()
+// GetBackupSchedule reports the automatic backup schedule, its retention policy and recent outcomes
+func (c AdminController) GetBackupSchedule() revel.Result {
+	return c.RenderJSON(db.BackupScheduleStatus())
+}
 
-	tables := map[string]func() [][]string{
-		"ElectionSettings": db.GetElectionSettingsData,
-		"Voters":           db.GetVotersData,
-		"Votes":            db.GetVotesData,
-		"Candidates":       db.GetCandidatesData,
+// GenerateBackup starts exporting the election as an Excel workbook, or as a zip of CSV files, a JSON
+// document or a SQL dump when "format" is csv, json or sql. The backup is generated in the background;
+// its progress is reported by GetBackupJob.
+func (c AdminController) GenerateBackup() revel.Result {
+	format := c.Params.Get("format")
+	if format == "" {
+		format = db.FormatXLSX
+	}
+
+	job, err := db.StartBackupJob(c.Username, format)
+	if err == db.ErrUnknownFormat {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Format must be xlsx, csv, json or sql"})
+	}
+	if err == db.ErrBackupInProgress {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]interface{}{"error": "A backup is already being generated", "job": job})
+	}
+	if err == db.ErrBackupKeyNotSet {
+		c.Response.Status = http.StatusServiceUnavailable
+		return c.RenderJSON(map[string]string{"error": "Backups are disabled until BACKUP_ENCRYPTION_KEY is set"})
+	}
+	if err != nil {
+		revel.AppLog.Errorf("Failed to start backup: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to generate backup"})
 	}
 
-	firstSheet := true
+	c.Response.Status = http.StatusAccepted
+	return c.RenderJSON(map[string]interface{}{"message": "Backup started", "job": job})
+}
 
-	for sheetName, fetchFunc := range tables {
-		data := fetchFunc()
+// GetBackupJob reports the progress of a backup started by GenerateBackup
+func (c AdminController) GetBackupJob(id string) revel.Result {
+	job, ok := db.GetBackupJob(id)
+	if !ok {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Backup job not found"})
+	}
+	return c.RenderJSON(job)
+}
 
-		// Log the data being written to the sheet
-		revel.AppLog.Infof("Data for sheet '%s': %v", sheetName, data)
+// readBackup loads the sheets of the backup to restore: a stored backup named by "filename", or an
+// uploaded "backup" file. Encrypted backups are decrypted with the configured key. Only Excel backups
+// can be restored; CSV, JSON and SQL exports are refused.
+func (c *AdminController) readBackup() (string, map[string][][]string, revel.Result) {
+	var data []byte
+	filename := c.Params.Get("filename")
 
-		index, err := addSheetWithData(f, sheetName, data)
+	if filename != "" {
+		manifest, err := db.GetBackupManifest(filename)
 		if err != nil {
-			revel.AppLog.Errorf("Failed to add %s sheet: %v", sheetName, err)
-			return c.RenderJSON(map[string]string{"error": fmt.Sprintf("Failed to add %s sheet", sheetName)})
+			revel.AppLog.Errorf("Failed to read manifest of %s: %v", filename, err)
+		}
+		if manifest != nil && manifest.Format != "" && manifest.Format != db.FormatXLSX {
+			c.Response.Status = http.StatusBadRequest
+			return "", nil, c.RenderJSON(map[string]string{
+				"error": "Only Excel (xlsx) backups can be restored; this backup is a " + manifest.Format + " export",
+			})
 		}
 
-		// Set active after creation
-		f.SetActiveSheet(index)
+		backup, err := db.ReadBackup(filename)
+		if err == db.ErrObjectNotFound {
+			c.Response.Status = http.StatusNotFound
+			return "", nil, c.RenderJSON(map[string]string{"error": "Backup not found"})
+		}
+		if err != nil {
+			revel.AppLog.Errorf("Failed to read backup %s: %v", filename, err)
+			c.Response.Status = http.StatusUnprocessableEntity
+			return "", nil, c.RenderJSON(map[string]string{"error": "Backup could not be read: " + err.Error()})
+		}
+		data = backup
+	} else {
+		files := c.Params.Files["backup"]
+		if len(files) == 0 {
+			c.Response.Status = http.StatusBadRequest
+			return "", nil, c.RenderJSON(map[string]string{"error": "Name a stored backup or upload a backup file"})
+		}
+		if files[0].Size > db.MaxRestoreBytes() {
+			c.Response.Status = http.StatusRequestEntityTooLarge
+			return "", nil, c.RenderJSON(map[string]string{"error": "Backup file is too large"})
+		}
 
-		// Delete "Sheet1" only once after first real sheet is added
-		if firstSheet {
-			if f.GetSheetName(0) == "Sheet1" {
-				f.DeleteSheet("Sheet1")
-			}
-			firstSheet = false
+		file, err := files[0].Open()
+		if err != nil {
+			revel.AppLog.Errorf("Failed to open uploaded backup: %v", err)
+			c.Response.Status = http.StatusBadRequest
+			return "", nil, c.RenderJSON(map[string]string{"error": "Failed to open backup file"})
 		}
-	}
+		defer file.Close()
+		filename = files[0].Filename
 
-	if err := os.MkdirAll("backup", os.ModePerm); err != nil {
-		revel.AppLog.Errorf("Failed to create backup directory: %v", err)
-		return c.RenderJSON(map[string]string{"error": "Failed to create backup directory"})
+		upload, err := io.ReadAll(io.LimitReader(file, db.MaxRestoreBytes()))
+		if err == nil {
+			data, err = db.DecryptBackup(upload)
+		}
+		if err != nil {
+			c.Response.Status = http.StatusUnprocessableEntity
+			return "", nil, c.RenderJSON(map[string]string{"error": "Backup could not be read: " + err.Error()})
+		}
 	}
 
-	if err := f.SaveAs(filePath); err != nil {
-		revel.AppLog.Errorf("Failed to save backup file: %v", err)
-		return c.RenderJSON(map[string]string{"error": "Failed to generate backup"})
+	if format := db.DetectExportFormat(data); format != db.FormatXLSX && format != "" {
+		c.Response.Status = http.StatusBadRequest
+		return "", nil, c.RenderJSON(map[string]string{
+			"error": "Only Excel (xlsx) backups can be restored; this backup is a " + format + " export",
+		})
 	}
 
-	return c.RenderJSON(map[string]string{"message": "Backup created successfully!"})
-}
-
-func addSheetWithData(f *excelize.File, sheetName string, data [][]string) (int, error) {
-	index, err := f.NewSheet(sheetName)
+	sheets, err := db.ReadBackupSheets(bytes.NewReader(data))
 	if err != nil {
-		return 0, fmt.Errorf("failed to create sheet %s: %w", sheetName, err)
+		revel.AppLog.Errorf("Failed to read backup %s: %v", filename, err)
+		c.Response.Status = http.StatusBadRequest
+		return "", nil, c.RenderJSON(map[string]string{"error": "Backup file is not a readable Excel workbook"})
 	}
+	return filename, sheets, nil
+}
 
-	if len(data) == 0 {
-		data = [][]string{{"No Data Available"}}
+// PreviewRestore validates a backup and lists how restoring it would change the database
+func (c *AdminController) PreviewRestore() revel.Result {
+	filename, sheets, result := c.readBackup()
+	if result != nil {
+		return result
 	}
 
-	for rowIndex, row := range data {
-		for colIndex, cell := range row {
-			cellRef, _ := excelize.CoordinatesToCellName(colIndex+1, rowIndex+1)
-
-			// Sanitize cell value
-			cell = db.SanitizeCellValue(cell)
+	preview, issues, err := db.PreviewRestore(filename, sheets)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to preview restore of %s: %v", filename, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to compare the backup with the database"})
+	}
+	if len(issues) > 0 {
+		c.Response.Status = http.StatusUnprocessableEntity
+		return c.RenderJSON(map[string]interface{}{
+			"error":  "The backup is not valid and cannot be restored",
+			"issues": issues,
+		})
+	}
 
-			// Check for empty data
-			if cell == "" {
-				cell = "[Empty]"
-			}
+	return c.RenderJSON(preview)
+}
 
-			// Check for excessive cell size
-			if len(cell) > 32767 {
-				revel.AppLog.Warnf("Cell at %d,%d exceeds Excel's character limit (32,767): truncating", rowIndex+1, colIndex+1)
-				cell = cell[:32767]
-			}
+// RestoreBackup replaces the election data with a backup's. It is refused while voting is open.
+func (c *AdminController) RestoreBackup() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+	if db.ElectionOpen() {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]string{"error": "A backup cannot be restored while the election is open"})
+	}
 
-			// Log potential XML issues
-			if strings.Contains(cell, "&") || strings.Contains(cell, "<") || strings.Contains(cell, ">") {
-				revel.AppLog.Warnf("Potential XML issue in sheet '%s', cell %s: %q", sheetName, cellRef, cell)
-			}
+	filename, sheets, result := c.readBackup()
+	if result != nil {
+		return result
+	}
 
-			// Write to sheet
-			f.SetCellValue(sheetName, cellRef, cell)
-		}
+	restored, issues, err := db.RestoreBackup(filename, sheets, c.Username)
+	if errors.Is(err, db.ErrElectionOpen) {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]string{"error": "A backup cannot be restored while the election is open"})
+	}
+	if err != nil {
+		revel.AppLog.Errorf("Failed to restore backup %s: %v", filename, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to restore the backup; the database was not changed"})
+	}
+	if len(issues) > 0 {
+		c.Response.Status = http.StatusUnprocessableEntity
+		return c.RenderJSON(map[string]interface{}{
+			"error":  "The backup is not valid and cannot be restored",
+			"issues": issues,
+		})
 	}
 
-	return index, nil
+	return c.RenderJSON(map[string]interface{}{
+		"message":  "Backup restored successfully",
+		"restored": restored,
+	})
 }
 
 func (c *AdminController) UpdateCandidate() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
 	type UpdateRequest struct {
+		ID           int64  `json:"id"`
 		PositionName string `json:"position_name"`
 		Name         string `json:"name"`
 		Position     string `json:"position"`
 		YearLevel    string `json:"year_level"`
 		Program      string `json:"program"`
 		Partylist    string `json:"partylist"`
+		PartylistID  *int64 `json:"partylist_id"`
 	}
 
 	var req UpdateRequest
@@ -508,54 +710,65 @@ func (c *AdminController) UpdateCandidate() revel.Result {
 	}
 
 	// Validate required fields
-	if req.PositionName == "" || req.Name == "" || req.Position == "" || req.Partylist == "" {
+	if (req.ID == 0 && req.PositionName == "") || req.Name == "" || req.Position == "" {
 		c.Response.Status = 400
 		return c.RenderJSON(map[string]interface{}{"error": "Missing required fields"})
 	}
 
-	// Update query
-	query := `UPDATE candidates SET
-		name = ?,
-		position = ?, 
-		year_level = ?, 
-		program = ?, 
-		partylist = ? 
-		WHERE position_name = ?`
-
-	revel.AppLog.Infof("Executing query with values: %v, %v, %v, %v, %v, %v",
-		req.Name, req.Position, req.YearLevel, req.Program, req.Partylist, req.PositionName)
-
-	result, err := c.DB.Exec(query,
-		req.Name,
-		req.Position,
-		req.YearLevel,
-		req.Program,
-		req.Partylist,
-		req.PositionName,
-	)
-
-	if err != nil {
-		revel.AppLog.Errorf("DB update failed: %v", err)
-		c.Response.Status = 500
-		return c.RenderJSON(map[string]interface{}{"error": "Database update failed"})
-	}
-
-	rowsAffected, _ := result.RowsAffected()
-	revel.AppLog.Infof("Rows affected: %d", rowsAffected)
-	if rowsAffected == 0 {
+	// Older clients identify the candidate by position_name, which stays fixed when the candidate is renamed
+	candidateID, err := db.ResolveCandidateID(req.ID, req.PositionName)
+	if err == db.ErrCandidateNotFound {
 		c.Response.Status = 404
 		return c.RenderJSON(map[string]interface{}{
 			"error": "Candidate not found",
-			"details": map[string]string{
+			"details": map[string]interface{}{
+				"id":            req.ID,
 				"position_name": req.PositionName,
-				"name":          req.Name,
 			},
 		})
+	} else if err != nil {
+		revel.AppLog.Errorf("Failed to look up candidate: %v", err)
+		c.Response.Status = 500
+		return c.RenderJSON(map[string]interface{}{"error": "Database update failed"})
+	}
+
+	// An empty partylist makes the candidate an independent; other partylists must already exist
+	issues, err := db.UpdateCandidate(candidateID, models.Candidate{
+		Name:        req.Name,
+		Position:    req.Position,
+		YearLevel:   req.YearLevel,
+		Program:     req.Program,
+		Partylist:   req.Partylist,
+		PartylistID: req.PartylistID,
+	}, c.Username)
+	if len(issues) > 0 {
+		c.Response.Status = http.StatusUnprocessableEntity
+		return c.RenderJSON(map[string]interface{}{
+			"error":  "Candidate is invalid; nothing was saved",
+			"issues": issues,
+		})
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, db.ErrUnknownPartylist):
+		c.Response.Status = 400
+		return c.RenderJSON(map[string]interface{}{"error": err.Error()})
+	case errors.Is(err, db.ErrCandidateLocked):
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]interface{}{"error": err.Error()})
+	case err == db.ErrCandidateNotFound:
+		c.Response.Status = 404
+		return c.RenderJSON(map[string]interface{}{"error": "Candidate not found"})
+	default:
+		revel.AppLog.Errorf("Failed to update candidate %d: %v", candidateID, err)
+		c.Response.Status = 500
+		return c.RenderJSON(map[string]interface{}{"error": "Database update failed"})
 	}
 
 	return c.RenderJSON(map[string]interface{}{
 		"success": true,
-		"data": map[string]string{
+		"data": map[string]interface{}{
+			"id":            candidateID,
 			"position_name": req.PositionName,
 			"name":          req.Name,
 			"position":      req.Position,
@@ -565,9 +778,14 @@ func (c *AdminController) UpdateCandidate() revel.Result {
 }
 
 func (c *AdminController) UpdateCredentials() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
 	var req struct {
-		PositionName string   `json:"position_name"`
-		Credentials  []string `json:"credentials"`
+		ID           int64               `json:"id"`
+		PositionName string              `json:"position_name"`
+		Credentials  []models.Credential `json:"credentials"`
 	}
 
 	if err := c.Params.BindJSON(&req); err != nil {
@@ -575,16 +793,28 @@ func (c *AdminController) UpdateCredentials() revel.Result {
 		return c.RenderJSON(map[string]string{"error": "Invalid request payload"})
 	}
 
-	credentialsJSON, err := json.Marshal(req.Credentials)
+	credentials, err := db.NormalizeCredentials(req.Credentials)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Invalid credentials: " + err.Error()})
+	}
+
+	candidateID, err := db.ResolveCandidateID(req.ID, req.PositionName)
+	if err != nil {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Candidate not found"})
+	}
+
+	credentialsJSON, err := json.Marshal(credentials)
 	if err != nil {
 		revel.AppLog.Errorf("Failed to marshal credentials: %v", err)
 		return c.RenderJSON(map[string]string{"error": "Failed to process credentials"})
 	}
 
-	query := `UPDATE candidates SET credentials = ? WHERE position_name = ?`
-	_, err = c.DB.Exec(query, string(credentialsJSON), req.PositionName)
+	query := `UPDATE candidates SET credentials = ? WHERE id = ?`
+	_, err = c.DB.Exec(query, string(credentialsJSON), candidateID)
 	if err != nil {
-		revel.AppLog.Errorf("Failed to update credentials for %s: %v", req.PositionName, err)
+		revel.AppLog.Errorf("Failed to update credentials for candidate %d: %v", candidateID, err)
 		return c.RenderJSON(map[string]string{"error": "Failed to update credentials"})
 	}
 
@@ -592,6 +822,10 @@ func (c *AdminController) UpdateCredentials() revel.Result {
 }
 
 func (c *AdminController) GetVotesTally() revel.Result {
+	if result := c.refuseIfEmbargoed(); result != nil {
+		return result
+	}
+
     rows, err := c.DB.Query(`
         SELECT 
             p.title AS position_title,
@@ -662,3 +896,420 @@ func (c *AdminController) GetVotesTally() revel.Result {
 
     return c.RenderJSON(positions)
 }
+
+func (c *AdminController) GetVoterRecord(student_id string) revel.Result {
+	row := c.DB.QueryRow(`SELECT fingerprint_hash, student_id, student_name, program, has_voted FROM voters WHERE student_id = ?`, student_id)
+
+	var voter models.Voter
+	err := row.Scan(
+		&voter.FingerprintHash,
+		&voter.StudentID,
+		&voter.StudentName,
+		&voter.Program,
+		&voter.HasVoted,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "ID not found. Student is not registered."})
+		}
+		revel.AppLog.Error("Error fetching voter data: ", "error", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch voter data"})
+	}
+
+	return c.RenderJSON(voter)
+}
+
+func (c *AdminController) EnrollKiosk() revel.Result {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.Params.BindJSON(&request); err != nil || strings.TrimSpace(request.Name) == "" {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Kiosk name is required"})
+	}
+
+	kioskID, secret, err := db.GenerateKioskCredentials()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to generate kiosk credentials: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to generate kiosk credentials"})
+	}
+
+	_, err = c.DB.Exec(`INSERT INTO kiosks (kiosk_id, name, secret) VALUES (?, ?, ?)`, kioskID, strings.TrimSpace(request.Name), secret)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to enroll kiosk %s: %v", request.Name, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to enroll kiosk"})
+	}
+
+	// The secret is only returned here; it has to be configured on the device right away
+	return c.RenderJSON(map[string]string{
+		"message":  "Kiosk enrolled successfully",
+		"kiosk_id": kioskID,
+		"secret":   secret,
+	})
+}
+
+func (c *AdminController) RevokeKiosk(kiosk_id string) revel.Result {
+	result, err := c.DB.Exec(`UPDATE kiosks SET is_revoked = TRUE, revoked_at = NOW() WHERE kiosk_id = ?`, kiosk_id)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to revoke kiosk %s: %v", kiosk_id, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to revoke kiosk"})
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Kiosk not found"})
+	}
+
+	return c.RenderJSON(map[string]string{"message": "Kiosk revoked successfully"})
+}
+
+func (c *AdminController) GetKiosks() revel.Result {
+	rows, err := c.DB.Query(`SELECT kiosk_id, name, is_revoked, created_at FROM kiosks ORDER BY created_at`)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch kiosks: %v", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch kiosks"})
+	}
+	defer rows.Close()
+
+	kiosks := []models.Kiosk{}
+	for rows.Next() {
+		var kiosk models.Kiosk
+		if err := rows.Scan(&kiosk.KioskID, &kiosk.Name, &kiosk.IsRevoked, &kiosk.CreatedAt); err != nil {
+			revel.AppLog.Errorf("Failed to scan kiosk: %v", err)
+			continue
+		}
+		kiosks = append(kiosks, kiosk)
+	}
+
+	return c.RenderJSON(kiosks)
+}
+
+func (c *AdminController) GetKioskStatus() revel.Result {
+	rows, err := c.DB.Query(`
+		SELECT kiosk_id, name, is_revoked,
+			COALESCE(last_heartbeat_at, ''), TIMESTAMPDIFF(SECOND, last_heartbeat_at, NOW()),
+			COALESCE(software_version, ''), COALESCE(reader_status, ''), queue_length,
+			COALESCE(reported_last_ballot_at, ''), COALESCE(last_ballot_at, ''), ballots_cast
+		FROM kiosks
+		ORDER BY name
+	`)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch kiosk status: %v", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch kiosk status"})
+	}
+	defer rows.Close()
+
+	silentAfter := int64(db.KioskSilentThreshold().Seconds())
+
+	kiosks := []models.KioskStatus{}
+	silent := []string{}
+	totalBallots := 0
+	for rows.Next() {
+		var kiosk models.KioskStatus
+		var secondsSinceSeen sql.NullInt64
+		err := rows.Scan(&kiosk.KioskID, &kiosk.Name, &kiosk.IsRevoked,
+			&kiosk.LastHeartbeatAt, &secondsSinceSeen,
+			&kiosk.SoftwareVersion, &kiosk.ReaderStatus, &kiosk.QueueLength,
+			&kiosk.ReportedLastBallotAt, &kiosk.LastBallotAt, &kiosk.BallotsCast)
+		if err != nil {
+			revel.AppLog.Errorf("Failed to scan kiosk status: %v", err)
+			continue
+		}
+
+		kiosk.SecondsSinceSeen = -1
+		if secondsSinceSeen.Valid {
+			kiosk.SecondsSinceSeen = secondsSinceSeen.Int64
+			kiosk.Online = secondsSinceSeen.Int64 <= silentAfter
+		}
+		if !kiosk.Online && !kiosk.IsRevoked {
+			silent = append(silent, kiosk.KioskID)
+		}
+
+		totalBallots += kiosk.BallotsCast
+		kiosks = append(kiosks, kiosk)
+	}
+
+	return c.RenderJSON(map[string]interface{}{
+		"kiosks":               kiosks,
+		"silent_kiosks":        silent,
+		"silent_after_seconds": silentAfter,
+		"total_ballots":        totalBallots,
+	})
+}
+
+func (c *AdminController) GetWinners() revel.Result {
+	if result := c.refuseIfEmbargoed(); result != nil {
+		return result
+	}
+
+	outcomes, err := db.ComputeOutcomes()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to compute winners: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to compute winners"})
+	}
+
+	unresolved := []string{}
+	for _, outcome := range outcomes {
+		if outcome.Status == db.OutcomeTied {
+			unresolved = append(unresolved, outcome.Position)
+		}
+	}
+
+	return c.RenderJSON(map[string]interface{}{
+		"positions":       outcomes,
+		"winners":         db.FinalWinners(outcomes),
+		"unresolved_ties": unresolved,
+	})
+}
+
+func (c *AdminController) ResolveTie() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	var request models.TieBreak
+	if err := c.Params.BindJSON(&request); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
+	}
+
+	if request.Position == "" || len(request.Winners) == 0 {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Position and winners are required"})
+	}
+	if !db.TieBreakMethods[request.Method] {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Method must be coin_toss, runoff, draw_lots or other"})
+	}
+
+	request.ResolvedBy = c.Username
+	if err := db.SaveTieBreak(request); err != nil {
+		if errors.Is(err, db.ErrInvalidTieBreak) {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJSON(map[string]string{"error": err.Error()})
+		}
+		revel.AppLog.Errorf("Failed to record tie-break for %s: %v", request.Position, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to record tie-break"})
+	}
+
+	return c.RenderJSON(map[string]string{"message": "Tie-break recorded successfully"})
+}
+
+func (c *AdminController) CertifyElection() revel.Result {
+	record, err := db.CertifyElection(c.Username)
+	if err != nil {
+		if errors.Is(err, db.ErrCertificationRefused) {
+			c.Response.Status = http.StatusConflict
+			return c.RenderJSON(map[string]string{"error": err.Error()})
+		}
+		revel.AppLog.Errorf("Failed to certify election: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to certify election"})
+	}
+
+	return c.RenderJSON(map[string]interface{}{
+		"message": "Election certified successfully",
+		"canvass": record,
+	})
+}
+
+func (c *AdminController) PostPositions() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	var positions []models.Position
+	if err := c.Params.BindJSON(&positions); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
+	}
+
+	for i := range positions {
+		if err := db.ValidatePosition(&positions[i]); err != nil {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJSON(map[string]string{"error": err.Error()})
+		}
+	}
+
+	if err := db.SavePositions(positions); errors.Is(err, db.ErrPositionLocked) {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]string{"error": err.Error()})
+	} else if err != nil {
+		revel.AppLog.Errorf("Failed to save positions: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to save positions"})
+	}
+
+	db.InvalidateResults()
+	return c.RenderJSON(map[string]string{"message": "Positions saved successfully"})
+}
+
+// PostPartylist creates a partylist, or updates the one with the given ID
+func (c *AdminController) PostPartylist() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	var partylist models.Partylist
+	if err := c.Params.BindJSON(&partylist); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
+	}
+
+	if err := db.ValidatePartylist(partylist); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": err.Error()})
+	}
+
+	id, err := db.SavePartylist(c.DB, partylist)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "Partylist not found"})
+		}
+		revel.AppLog.Errorf("Failed to save partylist %s: %v", partylist.Name, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to save partylist"})
+	}
+
+	db.InvalidateResults()
+	return c.RenderJSON(map[string]interface{}{
+		"message": "Partylist saved successfully",
+		"id":      id,
+	})
+}
+
+func (c *AdminController) DeletePartylist(id int64) revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	if err := db.DeletePartylist(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "Partylist not found"})
+		}
+		if errors.Is(err, db.ErrPartylistInUse) {
+			c.Response.Status = http.StatusConflict
+			return c.RenderJSON(map[string]string{"error": "Move or remove the partylist's candidates first"})
+		}
+		revel.AppLog.Errorf("Failed to delete partylist %d: %v", id, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to delete partylist"})
+	}
+
+	return c.RenderJSON(map[string]string{"message": "Partylist deleted successfully"})
+}
+
+// DeleteCandidate removes a candidate, its credentials, vote row and photo before voting starts.
+// The candidate is given by ID, or by its legacy position_name.
+func (c *AdminController) DeleteCandidate(candidate string) revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	candidateID, err := db.LookupCandidate(candidate)
+	if err == db.ErrCandidateNotFound {
+		c.Response.Status = http.StatusNotFound
+		return c.RenderJSON(map[string]string{"error": "Candidate not found"})
+	} else if err != nil {
+		revel.AppLog.Errorf("Failed to look up candidate %s: %v", candidate, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to delete candidate"})
+	}
+
+	photoURL, thumbnailURL, err := db.DeleteCandidate(candidateID, c.Username)
+	if err != nil {
+		if err == db.ErrCandidateNotFound {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "Candidate not found"})
+		}
+		if errors.Is(err, db.ErrCandidateLocked) {
+			c.Response.Status = http.StatusConflict
+			return c.RenderJSON(map[string]string{"error": err.Error()})
+		}
+		revel.AppLog.Errorf("Failed to delete candidate %d: %v", candidateID, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to delete candidate"})
+	}
+
+	removeUnusedPhotos(photoURL, thumbnailURL)
+
+	return c.RenderJSON(map[string]string{"message": "Candidate deleted successfully"})
+}
+
+// WithdrawCandidate takes a candidate off the ballot while keeping the votes already cast for them
+func (c *AdminController) WithdrawCandidate() revel.Result {
+	if result := c.refuseIfCertified(); result != nil {
+		return result
+	}
+
+	var request struct {
+		ID           int64  `json:"id"`
+		PositionName string `json:"position_name"`
+		Reason       string `json:"reason"`
+	}
+	if err := c.Params.BindJSON(&request); err != nil || (request.ID == 0 && request.PositionName == "") {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "id or position_name is required"})
+	}
+
+	candidateID, err := db.ResolveCandidateID(request.ID, request.PositionName)
+	if err == nil {
+		err = db.WithdrawCandidate(candidateID, c.Username, request.Reason)
+	}
+	if err != nil {
+		if err == db.ErrCandidateNotFound {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "Candidate not found"})
+		}
+		if errors.Is(err, db.ErrCandidateLocked) {
+			c.Response.Status = http.StatusConflict
+			return c.RenderJSON(map[string]string{"error": err.Error()})
+		}
+		revel.AppLog.Errorf("Failed to withdraw candidate %d (%s): %v", request.ID, request.PositionName, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to withdraw candidate"})
+	}
+
+	return c.RenderJSON(map[string]string{"message": "Candidate withdrawn successfully"})
+}
+
+func (c *AdminController) GetAuditLog(limit int) revel.Result {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	entries, err := db.GetAuditLog(limit)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch audit log: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch audit log"})
+	}
+
+	return c.RenderJSON(entries)
+}
+
+// removeUnusedPhotos deletes photo files that no candidate refers to any more. Files are named by
+// content, so candidates uploading the same picture share them.
+func removeUnusedPhotos(filenames ...string) {
+	for _, filename := range filenames {
+		if filename == "" {
+			continue
+		}
+		if inUse, err := db.PhotoInUse(filename); err != nil || inUse {
+			continue
+		}
+
+		key := filepath.Base(filename)
+		if err := db.Photos.Delete(key); err != nil && err != db.ErrObjectNotFound {
+			revel.AppLog.Errorf("Failed to delete photo %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"api/app/db"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// BackupController serves backup downloads. Browsers follow download links without the admin token,
+// so requests are authenticated by the link's signature instead; links are only issued to admins.
+type BackupController struct {
+	*revel.Controller
+	DB *sql.DB
+}
+
+func (c *BackupController) SetDB() revel.Result {
+	c.DB = db.DBInstance()
+	return nil
+}
+
+// DownloadBackup streams a decrypted backup for a link signed by db.BackupDownloadURL
+func (c BackupController) DownloadBackup(filename string, expires int64, signature string) revel.Result {
+	if !db.ValidBackupDownload(filename, expires, signature) {
+		c.Response.Status = http.StatusForbidden
+		return c.RenderJSON(map[string]string{"error": "Download link is invalid or has expired"})
+	}
+
+	backup, err := db.OpenBackup(filename)
+	if err != nil {
+		if err == db.ErrObjectNotFound {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "Backup not found"})
+		}
+		revel.AppLog.Errorf("Failed to read backup %s: %v", filename, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to read backup"})
+	}
+
+	// The backup is decrypted as it is sent; RenderBinary closes it
+	c.Response.Out.Header().Set("Cache-Control", "no-store")
+	return c.RenderBinary(backup, filename, revel.Attachment, time.Now())
+}
@@ -4,7 +4,9 @@ import (
 	"api/app/db"
 	"api/app/models"
 	"database/sql"
-	"encoding/json"
+	"net/http"
+	"path"
+	"time"
 
 	"github.com/revel/revel"
 )
@@ -19,8 +21,14 @@ func (c *CandidatesController) SetDB() revel.Result {
 	return nil
 }
 
-func (c CandidatesController) GetAllCandidates() revel.Result {
-	rows, err := db.DB.Query(`SELECT position_name, name, position, year_level, program, partylist, credentials, photo_url FROM candidates`)
+// GetAllCandidates lists the candidates on the ballot. Withdrawn candidates are only included with include_withdrawn=true.
+func (c CandidatesController) GetAllCandidates(include_withdrawn bool) revel.Result {
+	query := `SELECT id, position_name, name, position, year_level, program, partylist, partylist_id, credentials, photo_url, COALESCE(thumbnail_url, ''), withdrawn_at IS NOT NULL FROM candidates`
+	if !include_withdrawn {
+		query += ` WHERE withdrawn_at IS NULL`
+	}
+
+	rows, err := db.DB.Query(query)
 	if err != nil {
 		revel.AppLog.Error("Error fetching candidates: ", "error", err)
 		return c.RenderJSON(map[string]string{"error": "Failed to fetch candidates"})
@@ -31,24 +39,42 @@ func (c CandidatesController) GetAllCandidates() revel.Result {
 	for rows.Next() {
 		var candidate models.Candidate
 		var credentialsJSON sql.NullString
+		var partylistID sql.NullInt64
 
 		err := rows.Scan(
+			&candidate.ID,
 			&candidate.PositionName,
 			&candidate.Name,
 			&candidate.Position,
 			&candidate.YearLevel,
 			&candidate.Program,
 			&candidate.Partylist,
+			&partylistID,
 			&credentialsJSON,
 			&candidate.PhotoURL,
+			&candidate.ThumbnailURL,
+			&candidate.Withdrawn,
 		)
 		if err != nil {
 			revel.AppLog.Error("Error scanning candidate data: ", "error", err)
 			return c.RenderJSON(map[string]string{"error": "Failed to parse candidate data"})
 		}
 
+		if partylistID.Valid {
+			candidate.PartylistID = &partylistID.Int64
+		}
+
+		// Legacy rows store photos as "/uploads/name.jpg"; only the file name is a storage key
+		if candidate.PhotoURL != "" {
+			candidate.PhotoSrc, _ = db.Photos.URL(path.Base(candidate.PhotoURL))
+		}
+		if candidate.ThumbnailURL != "" {
+			candidate.ThumbnailSrc, _ = db.Photos.URL(candidate.ThumbnailURL)
+		}
+
+		// Credentials stored before they were structured are plain strings and come back as type "other"
 		if credentialsJSON.Valid {
-			if err := json.Unmarshal([]byte(credentialsJSON.String), &candidate.Credentials); err != nil {
+			if candidate.Credentials, err = db.ParseCredentials(credentialsJSON.String); err != nil {
 				revel.AppLog.Error("Error parsing credentials JSON: ", "error", err, "raw", credentialsJSON.String)
 				return c.RenderJSON(map[string]string{"error": "Failed to parse credentials JSON"})
 			}
@@ -59,3 +85,55 @@ func (c CandidatesController) GetAllCandidates() revel.Result {
 
 	return c.RenderJSON(candidates)
 }
+
+func (c CandidatesController) GetPositions() revel.Result {
+	positions, err := db.GetPositions()
+	if err != nil {
+		revel.AppLog.Error("Error fetching positions: ", "error", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch positions"})
+	}
+
+	return c.RenderJSON(positions)
+}
+
+func (c CandidatesController) GetPartylists() revel.Result {
+	partylists, err := db.GetPartylists()
+	if err != nil {
+		revel.AppLog.Error("Error fetching partylists: ", "error", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch partylists"})
+	}
+
+	return c.RenderJSON(partylists)
+}
+
+// GetPartylistSlates lists each partylist's candidates for side-by-side slate views
+func (c CandidatesController) GetPartylistSlates() revel.Result {
+	slates, err := db.GetPartylistSlates()
+	if err != nil {
+		revel.AppLog.Error("Error fetching partylist slates: ", "error", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch partylist slates"})
+	}
+
+	return c.RenderJSON(slates)
+}
+
+// ServePhoto streams a candidate photo from storage. Content-addressed photos never change, so they are
+// cached indefinitely; photos uploaded before content addressing are revalidated.
+func (c CandidatesController) ServePhoto(filepath string) revel.Result {
+	key := path.Base(filepath)
+	photo, err := db.Photos.Get(key)
+	if err != nil {
+		if err != db.ErrObjectNotFound {
+			revel.AppLog.Errorf("Failed to read photo %s: %v", key, err)
+		}
+		c.Response.Status = http.StatusNotFound
+		return c.RenderText("Photo not found")
+	}
+
+	if db.ImmutableKey(key) {
+		c.Response.Out.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Response.Out.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	return c.RenderBinary(photo, key, revel.Inline, time.Time{})
+}
@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"api/app/db"
+	"net/http"
+
+	"github.com/revel/revel"
+)
+
+// authenticateKiosk verifies the kiosk signature on the request and returns the kiosk ID
+func authenticateKiosk(c *revel.Controller) (string, revel.Result) {
+	kioskID := c.Request.Header.Get("X-Kiosk-ID")
+	timestamp := c.Request.Header.Get("X-Kiosk-Timestamp")
+	nonce := c.Request.Header.Get("X-Kiosk-Nonce")
+	signature := c.Request.Header.Get("X-Kiosk-Signature")
+
+	if !db.AuthenticateKiosk(kioskID, timestamp, nonce, signature, c.Request.Method, c.Request.URL.Path, c.Params.JSON) {
+		c.Response.Status = http.StatusUnauthorized
+		return "", c.RenderJSON(map[string]string{"error": "Kiosk is not authorized"})
+	}
+
+	return kioskID, nil
+}
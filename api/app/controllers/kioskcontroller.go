@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"api/app/db"
+	"api/app/models"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+type KioskController struct {
+	*revel.Controller
+	DB *sql.DB
+}
+
+func (c *KioskController) SetDB() revel.Result {
+	c.DB = db.DBInstance()
+	return nil
+}
+
+func (c *KioskController) PostHeartbeat() revel.Result {
+	kioskID, result := authenticateKiosk(c.Controller)
+	if result != nil {
+		return result
+	}
+
+	var request models.KioskHeartbeat
+	if err := c.Params.BindJSON(&request); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
+	}
+
+	// Kiosks report RFC3339 timestamps; the column holds local DATETIMEs like the election settings
+	var reportedLastBallot interface{}
+	if request.LastBallotAt != "" {
+		lastBallotAt, err := time.Parse(time.RFC3339, request.LastBallotAt)
+		if err != nil {
+			c.Response.Status = http.StatusBadRequest
+			return c.RenderJSON(map[string]string{"error": "Invalid last_ballot_at format. Use RFC3339, e.g. 2006-01-02T15:04:05Z"})
+		}
+		reportedLastBallot = lastBallotAt.In(time.Local).Format("2006-01-02 15:04:05")
+	}
+
+	_, err := c.DB.Exec(`
+		UPDATE kiosks SET
+			last_heartbeat_at = NOW(),
+			software_version = ?,
+			reader_status = ?,
+			queue_length = ?,
+			reported_last_ballot_at = ?
+		WHERE kiosk_id = ?
+	`, request.SoftwareVersion, request.ReaderStatus, request.QueueLength, reportedLastBallot, kioskID)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to record heartbeat for %s: %v", kioskID, err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to record heartbeat"})
+	}
+
+	return c.RenderJSON(map[string]string{"success": "Heartbeat recorded"})
+}
@@ -2,7 +2,12 @@ package controllers
 
 import (
 	"api/app/db"
+	"api/app/models"
 	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/revel/revel"
 )
@@ -17,7 +22,161 @@ func (c *LiveVotesController) SetDB() revel.Result {
 	return nil
 }
 
+// callerRole returns the admin role of the caller, or "" for the public. Admins may pass their token
+// in the Authorization header, or as a parameter where headers cannot be set (WebSockets).
+func callerRole(c *revel.Controller, token string) string {
+	if header := c.Request.Header.Get("Authorization"); header != "" {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	role := ""
+	if token != "" {
+		_, role, _ = db.AdminFromJWT(token)
+	}
+	return role
+}
+
+// activeEmbargo returns the results embargo that applies to the caller
+func activeEmbargo(c *revel.Controller, token string) string {
+	return db.ActiveEmbargo(callerRole(c, token))
+}
+
+func (c *LiveVotesController) renderEmbargoed() revel.Result {
+	c.Response.Status = http.StatusForbidden
+	return c.RenderJSON(map[string]interface{}{
+		"error":     "Results are embargoed until polls close",
+		"embargoed": true,
+	})
+}
+
+// notModifiedResult answers a conditional request whose ETag still matches
+type notModifiedResult struct{}
+
+func (r notModifiedResult) Apply(req *revel.Request, resp *revel.Response) {
+	resp.WriteHeader(http.StatusNotModified, "")
+}
+
+// GetResults returns every position with its candidates, department counts, abstentions and turnout in one response
+func (c *LiveVotesController) GetResults() revel.Result {
+	embargo := activeEmbargo(c.Controller, "")
+	if embargo == db.EmbargoHidden {
+		return c.renderEmbargoed()
+	}
+
+	results, etag, err := db.GetResults()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to compute results: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to retrieve results"})
+	}
+
+	if embargo == db.EmbargoTurnout {
+		return c.RenderJSON(map[string]interface{}{
+			"embargoed":    true,
+			"ballots_cast": results.BallotsCast,
+			"turnout":      results.Turnout,
+		})
+	}
+
+	c.Response.Out.Header().Set("ETag", etag)
+	c.Response.Out.Header().Set("Cache-Control", "no-cache")
+	if c.Request.Header.Get("If-None-Match") == etag {
+		return notModifiedResult{}
+	}
+
+	return c.RenderJSON(results)
+}
+
+func (c *LiveVotesController) GetTurnout() revel.Result {
+	if activeEmbargo(c.Controller, "") == db.EmbargoHidden {
+		return c.renderEmbargoed()
+	}
+
+	stats, err := db.GetTurnout()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to compute turnout: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to retrieve turnout"})
+	}
+
+	return c.RenderJSON(stats)
+}
+
+// GetPartylistResults totals votes and seats won per partylist, with independents grouped together
+func (c *LiveVotesController) GetPartylistResults() revel.Result {
+	if activeEmbargo(c.Controller, "") != db.EmbargoOff {
+		return c.renderEmbargoed()
+	}
+
+	results, err := db.GetPartylistResults()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to compute partylist results: %v", err)
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderJSON(map[string]string{"error": "Failed to retrieve partylist results"})
+	}
+
+	return c.RenderJSON(results)
+}
+
+// GetCanvass publishes the signed canvass record so anyone can check it against its digest and public key
+func (c *LiveVotesController) GetCanvass() revel.Result {
+	record, err := db.GetLatestCanvass()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Response.Status = http.StatusNotFound
+			return c.RenderJSON(map[string]string{"error": "The election has not been certified yet"})
+		}
+		revel.AppLog.Errorf("Failed to fetch canvass record: %v", err)
+		return c.RenderJSON(map[string]string{"error": "Failed to fetch canvass record"})
+	}
+
+	verification, err := db.VerifyCanvass(record)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to verify canvass record %d: %v", record.ID, err)
+		return c.RenderJSON(map[string]string{"error": "Failed to verify canvass record"})
+	}
+
+	return c.RenderJSON(map[string]interface{}{
+		"canvass":      record,
+		"verification": verification,
+	})
+}
+
+// OfficialResults renders the certified canvass as a printable report
+func (c *LiveVotesController) OfficialResults() revel.Result {
+	record, err := db.GetLatestCanvass()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NotFound("The election has not been certified yet")
+		}
+		revel.AppLog.Errorf("Failed to fetch canvass record: %v", err)
+		return c.RenderError(err)
+	}
+
+	var document models.CanvassDocument
+	if err := json.Unmarshal([]byte(record.Document), &document); err != nil {
+		revel.AppLog.Errorf("Failed to parse canvass record %d: %v", record.ID, err)
+		return c.RenderError(err)
+	}
+
+	verification, err := db.VerifyCanvass(record)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to verify canvass record %d: %v", record.ID, err)
+		return c.RenderError(err)
+	}
+
+	c.ViewArgs["title"] = "Official Election Results"
+	c.ViewArgs["record"] = record
+	c.ViewArgs["document"] = document
+	c.ViewArgs["verification"] = verification
+	return c.RenderTemplate("LiveVotesController/OfficialResults.html")
+}
+
 func (c *LiveVotesController) GetTotalVotes(position_name string) revel.Result {
+	if activeEmbargo(c.Controller, "") != db.EmbargoOff {
+		return c.renderEmbargoed()
+	}
+
 	var totalVotes int
 	err := c.DB.QueryRow("SELECT total_votes FROM votes WHERE position_name = ?", position_name).Scan(&totalVotes)
 	if err != nil {
@@ -35,6 +194,10 @@ func (c *LiveVotesController) GetTotalVotes(position_name string) revel.Result {
 }
 
 func (c *LiveVotesController) GetDepartmentVotes(position_name, department string) revel.Result {
+	if activeEmbargo(c.Controller, "") != db.EmbargoOff {
+		return c.renderEmbargoed()
+	}
+
 	query, err := db.GetDepartmentVoteQuery(department)
 	if err != nil {
 		return c.RenderJSON(map[string]string{"error": "Invalid department specified"})
@@ -56,3 +219,58 @@ func (c *LiveVotesController) GetDepartmentVotes(position_name, department strin
 		"total_votes":   departmentVotes,
 	})
 }
+
+// ResultsStream pushes tally deltas over a WebSocket as ballots are committed.
+// positions is an optional comma separated filter; last_event_id replays events missed while reconnecting.
+func (c *LiveVotesController) ResultsStream(ws revel.ServerWebSocket, positions string, last_event_id int64, token string) revel.Result {
+	if ws == nil {
+		return nil
+	}
+
+	role := callerRole(c.Controller, token)
+	if db.ActiveEmbargo(role) != db.EmbargoOff {
+		ws.MessageSendJSON(&db.TallyEvent{Type: "embargoed", Time: time.Now().Format(time.RFC3339)})
+		return nil
+	}
+
+	var filter []string
+	for _, position := range strings.Split(positions, ",") {
+		if position = strings.TrimSpace(position); position != "" {
+			filter = append(filter, position)
+		}
+	}
+
+	subscription := db.SubscribeTally(filter, last_event_id)
+	defer db.UnsubscribeTally(subscription)
+
+	// Clients never send anything meaningful, but reading is how a closed socket is noticed
+	closed := make(chan struct{})
+	go func() {
+		var message interface{}
+		for {
+			if err := ws.MessageReceiveJSON(&message); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return nil
+			}
+			// An embargo can start while the socket is open, when polls open
+			if db.ActiveEmbargo(role) != db.EmbargoOff {
+				ws.MessageSendJSON(&db.TallyEvent{Type: "embargoed", Time: time.Now().Format(time.RFC3339)})
+				return nil
+			}
+			if err := ws.MessageSendJSON(&event); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
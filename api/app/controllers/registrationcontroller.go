@@ -19,6 +19,10 @@ func (c *RegistrationController) SetDB() revel.Result {
 }
 
 func (c *RegistrationController) RegisterQr() revel.Result {
+	if _, result := authenticateKiosk(c.Controller); result != nil {
+		return result
+	}
+
 	var request struct {
 		StudentID   string `json:"student_id"`
 		StudentName string `json:"student_name"`
@@ -27,8 +27,8 @@ func (c *SigninController) Signin() revel.Result {
 		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
 	}
 
-	var hashedPassword string
-	err := c.DB.QueryRow("SELECT password_hash FROM admin WHERE username = ?", request.Username).Scan(&hashedPassword)
+	var hashedPassword, role string
+	err := c.DB.QueryRow("SELECT password_hash, role FROM admin WHERE username = ?", request.Username).Scan(&hashedPassword, &role)
 	if err == sql.ErrNoRows {
 		c.Response.Status = http.StatusBadRequest
 		return c.RenderJSON(map[string]string{"error": "Username not found"})
@@ -42,7 +42,7 @@ func (c *SigninController) Signin() revel.Result {
 		return c.RenderJSON(map[string]string{"error": "Incorrect password"})
 	}
 
-	tokenString, err := db.GenerateJWT(request.Username)
+	tokenString, err := db.GenerateJWT(request.Username, role)
 	if err != nil {
 		c.Response.Status = http.StatusInternalServerError
 		return c.RenderJSON(map[string]string{"error": "Could not generate token"})
@@ -11,6 +11,9 @@ import (
 	"github.com/revel/revel"
 )
 
+// Upper bound on the number of queued ballots accepted in a single sync request
+const maxSyncBallots = 500
+
 type VotingController struct {
 	*revel.Controller
 	DB *sql.DB
@@ -37,17 +40,19 @@ func (c *VotingController) GetElectionStatus() revel.Result {
 }
 
 func (c VotingController) GetVoter(student_id string) revel.Result {
-	row := c.DB.QueryRow(`SELECT fingerprint_hash, student_id, student_name, program, has_voted FROM voters WHERE student_id = ?`, student_id)
+	kioskID, result := authenticateKiosk(c.Controller)
+	if result != nil {
+		return result
+	}
 
-	var voter models.Voter
+	if !db.AllowKioskRequest(kioskID) {
+		c.Response.Status = http.StatusTooManyRequests
+		return c.RenderJSON(map[string]string{"error": "Too many lookups from this kiosk. Please wait a moment."})
+	}
 
-	err := row.Scan(
-		&voter.FingerprintHash,
-		&voter.StudentID,
-		&voter.StudentName,
-		&voter.Program,
-		&voter.HasVoted,
-	)
+	var studentName string
+	var hasVoted bool
+	err := c.DB.QueryRow(`SELECT student_name, has_voted FROM voters WHERE student_id = ?`, student_id).Scan(&studentName, &hasVoted)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.RenderJSON(map[string]string{"error": "ID not found. Student is not registered."})
@@ -56,40 +61,109 @@ func (c VotingController) GetVoter(student_id string) revel.Result {
 		return c.RenderJSON(map[string]string{"error": "Failed to fetch voter data"})
 	}
 
-	return c.RenderJSON(voter)
+	eligibility := "eligible"
+	if hasVoted {
+		eligibility = "already_voted"
+	}
+
+	return c.RenderJSON(models.VoterLookup{
+		MaskedName:  db.MaskName(studentName),
+		Eligibility: eligibility,
+	})
 }
 
 func (c *VotingController) PostVote() revel.Result {
+	kioskID, result := authenticateKiosk(c.Controller)
+	if result != nil {
+		return result
+	}
+
 	var request models.Votes
 	if err := c.Params.BindJSON(&request); err != nil {
 		c.Response.Status = http.StatusBadRequest
 		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
 	}
 
-	department, err := db.IdentifyDepartment(request.Program)
+	ballot, err := db.CastBallot(kioskID, request)
 	if err != nil {
-		return c.RenderJSON(map[string]string{"error": "Invalid program specified"})
+		revel.AppLog.Errorf("Failed to record ballot %s from kiosk %s: %v", request.BallotID, kioskID, err)
+		c.Response.Status = http.StatusServiceUnavailable
+		return c.RenderJSON(map[string]interface{}{
+			"error":     "Failed to record ballot. Keep it queued and retry.",
+			"ballot_id": request.BallotID,
+			"retry":     true,
+		})
 	}
 
-	votes := []string{request.GovernorVote, request.ViceGovernorVote, request.BoardMemberVote}
-	for _, vote := range votes {
-		query := fmt.Sprintf(`
-			UPDATE votes
-			SET %s = %s + 1
-			WHERE position_name = ?
-		`, department, department)
+	if ballot.Status == db.BallotRejected {
+		c.Response.Status = http.StatusConflict
+		return c.RenderJSON(map[string]interface{}{
+			"error":     ballot.Reason,
+			"ballot_id": ballot.BallotID,
+			"status":    ballot.Status,
+		})
+	}
 
-		_, err := c.DB.Exec(query, vote)
+	return c.RenderJSON(map[string]interface{}{
+		"success":   "Votes have been added",
+		"ballot_id": ballot.BallotID,
+		"status":    ballot.Status,
+	})
+}
+
+// SyncBallots accepts ballots a kiosk queued while offline and reports the outcome of each one
+func (c *VotingController) SyncBallots() revel.Result {
+	kioskID, result := authenticateKiosk(c.Controller)
+	if result != nil {
+		return result
+	}
+
+	var request struct {
+		Ballots []models.Votes `json:"ballots"`
+	}
+	if err := c.Params.BindJSON(&request); err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderJSON(map[string]string{"error": "Invalid request body"})
+	}
+
+	if len(request.Ballots) > maxSyncBallots {
+		c.Response.Status = http.StatusRequestEntityTooLarge
+		return c.RenderJSON(map[string]string{"error": fmt.Sprintf("At most %d ballots can be synced at once", maxSyncBallots)})
+	}
+
+	accepted := []string{}
+	alreadyCounted := []string{}
+	rejected := []db.BallotResult{}
+	retry := []string{}
+
+	for _, vote := range request.Ballots {
+		// Queued ballots must carry their own ID, otherwise a replay cannot be detected
+		if vote.BallotID == "" {
+			rejected = append(rejected, db.BallotResult{Status: db.BallotRejected, Reason: "Missing ballot ID"})
+			continue
+		}
+
+		ballot, err := db.CastBallot(kioskID, vote)
 		if err != nil {
-			return c.RenderJSON(map[string]string{"error": "Failed to update votes for " + vote})
+			revel.AppLog.Errorf("Failed to sync ballot %s from kiosk %s: %v", vote.BallotID, kioskID, err)
+			retry = append(retry, vote.BallotID)
+			continue
 		}
-	}
 
-	// Update voter's status
-	_, err = c.DB.Exec(`UPDATE voters SET has_voted = TRUE WHERE student_id = ?`, request.StudentID)
-	if err != nil {
-		return c.RenderJSON(map[string]string{"error": "Failed to update voting status"})
+		switch ballot.Status {
+		case db.BallotAccepted:
+			accepted = append(accepted, ballot.BallotID)
+		case db.BallotAlreadyCounted:
+			alreadyCounted = append(alreadyCounted, ballot.BallotID)
+		default:
+			rejected = append(rejected, ballot)
+		}
 	}
 
-	return c.RenderJSON(map[string]string{"success": "Votes have been added"})
+	return c.RenderJSON(map[string]interface{}{
+		"accepted":        accepted,
+		"already_counted": alreadyCounted,
+		"rejected":        rejected,
+		"retry":           retry,
+	})
 }
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"encoding/json"
+
+	"api/app/models"
+)
+
+// RecordAudit appends an entry to the admin audit log. Passing the transaction that made the change
+// keeps the entry and the change together.
+func RecordAudit(q queryer, username, action, target string, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`INSERT INTO audit_log (admin_username, action, target, details) VALUES (?, ?, ?, ?)`,
+		username, action, target, string(detailsJSON))
+	return err
+}
+
+// GetAuditLog returns the most recent audit entries, newest first
+func GetAuditLog(limit int) ([]models.AuditEntry, error) {
+	rows, err := DB.Query(`SELECT id, admin_username, action, target, COALESCE(details, 'null'), created_at FROM audit_log ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details string
+		if err := rows.Scan(&entry.ID, &entry.Username, &entry.Action, &entry.Target, &details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Details = json.RawMessage(details)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,486 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/revel/revel"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Backups written whole start with this header, followed by the GCM nonce and the sealed export.
+// Files with neither header are backups written before encryption and are read as they are.
+var encryptedBackupHeader = []byte("VKBACKUP-AESGCM1")
+
+// Backups written as they are exported start with this header, followed by a random nonce prefix and
+// the export sealed in chunks of backupChunkSize. Each chunk's nonce is the prefix, the chunk's
+// number and a flag marking the last chunk, so chunks cannot be reordered, dropped or cut off.
+var chunkedBackupHeader = []byte("VKBACKUP-AESGCM2")
+
+const (
+	backupChunkSize   = 64 << 10
+	backupNoncePrefix = 7
+)
+
+// ErrBackupKeyMissing is returned when reading an encrypted backup without BACKUP_ENCRYPTION_KEY set
+var ErrBackupKeyMissing = errors.New("backup is encrypted but BACKUP_ENCRYPTION_KEY is not set")
+
+// ErrBackupKeyNotSet is returned when writing a backup without BACKUP_ENCRYPTION_KEY set. Backups hold
+// voters' fingerprint hashes, so they are never written unencrypted.
+var ErrBackupKeyNotSet = errors.New("BACKUP_ENCRYPTION_KEY is not set; backups are not written unencrypted")
+
+// ErrBackupEncrypted is returned when encrypting a stored backup that is already encrypted
+var ErrBackupEncrypted = errors.New("backup is already encrypted")
+
+var backupKeyOnce = struct {
+	sync.Once
+	key []byte
+	err error
+}{}
+
+// backupKey reads the AES-256 key from BACKUP_ENCRYPTION_KEY, given as 32 bytes in base64 or hex.
+// It returns nil when no key is configured.
+func backupKey() ([]byte, error) {
+	backupKeyOnce.Do(func() {
+		value := os.Getenv("BACKUP_ENCRYPTION_KEY")
+		if value == "" {
+			return
+		}
+
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != 32 {
+			key, err = hex.DecodeString(value)
+		}
+		if err != nil || len(key) != 32 {
+			backupKeyOnce.err = errors.New("BACKUP_ENCRYPTION_KEY must be 32 bytes, base64 or hex encoded")
+			return
+		}
+		backupKeyOnce.key = key
+	})
+	return backupKeyOnce.key, backupKeyOnce.err
+}
+
+// InitBackupEncryption checks the backup key at startup so a bad key fails fast rather than at the
+// first backup
+func InitBackupEncryption() {
+	key, err := backupKey()
+	if err != nil {
+		revel.AppLog.Fatal("❌ Invalid backup encryption key:", "error", err)
+	}
+	if key == nil {
+		revel.AppLog.Error("❌ BACKUP_ENCRYPTION_KEY is not set; backups will be refused until it is.")
+	}
+}
+
+// requireBackupKey returns the backup key, or ErrBackupKeyNotSet when none is configured
+func requireBackupKey() ([]byte, error) {
+	key, err := backupKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrBackupKeyNotSet
+	}
+	return key, nil
+}
+
+func backupCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isEncryptedBackup reports whether data starts with either encrypted backup header
+func isEncryptedBackup(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedBackupHeader) || bytes.HasPrefix(data, chunkedBackupHeader)
+}
+
+// chunkNonce returns the nonce of a chunk of a chunked backup
+func chunkNonce(prefix []byte, chunk uint32, last bool) []byte {
+	nonce := make([]byte, backupNoncePrefix+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[backupNoncePrefix:], chunk)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// backupEncrypter seals what is written to it in chunks. Close seals the last chunk and must be called.
+type backupEncrypter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	prefix []byte
+	chunk  uint32
+	buffer []byte
+	sealed []byte
+}
+
+// newBackupEncrypter writes the chunked backup header to w and returns a writer that encrypts with
+// the configured key. Without a key it fails with ErrBackupKeyNotSet.
+func newBackupEncrypter(w io.Writer) (io.WriteCloser, error) {
+	key, err := requireBackupKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := backupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, backupNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, chunkedBackupHeader...), prefix...)); err != nil {
+		return nil, err
+	}
+	return &backupEncrypter{w: w, gcm: gcm, prefix: prefix, buffer: make([]byte, 0, backupChunkSize)}, nil
+}
+
+func (e *backupEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more follows it, so the last chunk is never full
+		if len(e.buffer) == backupChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buffer[len(e.buffer):backupChunkSize], p)
+		e.buffer = e.buffer[:len(e.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *backupEncrypter) seal(last bool) error {
+	e.sealed = e.gcm.Seal(e.sealed[:0], chunkNonce(e.prefix, e.chunk, last), e.buffer, nil)
+	if _, err := e.w.Write(e.sealed); err != nil {
+		return err
+	}
+	e.chunk++
+	e.buffer = e.buffer[:0]
+	return nil
+}
+
+func (e *backupEncrypter) Close() error {
+	if len(e.buffer) == backupChunkSize {
+		if err := e.seal(false); err != nil {
+			return err
+		}
+	}
+	return e.seal(true)
+}
+
+// backupDecrypter reads the plaintext of a chunked backup one chunk at a time. A chunk is only returned
+// once it is authenticated, so a modified or cut off backup fails with an error rather than yielding
+// forged data, though the chunks before the damage have already been read.
+type backupDecrypter struct {
+	r      io.Reader
+	gcm    cipher.AEAD
+	prefix []byte
+	chunk  uint32
+	sealed []byte
+	plain  []byte
+	last   bool
+	err    error
+}
+
+// newBackupDecrypter reads the chunked backup header from r and returns a reader of the plaintext
+func newBackupDecrypter(r io.Reader, gcm cipher.AEAD) (io.Reader, error) {
+	header := make([]byte, len(chunkedBackupHeader)+backupNoncePrefix)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New("encrypted backup is truncated")
+	}
+	if !bytes.HasPrefix(header, chunkedBackupHeader) {
+		return nil, errors.New("backup is not a chunked encrypted backup")
+	}
+	return &backupDecrypter{
+		r:      r,
+		gcm:    gcm,
+		prefix: header[len(chunkedBackupHeader):],
+		sealed: make([]byte, backupChunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func (d *backupDecrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.last {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and authenticates the next chunk. Only the last chunk is shorter than a full one, so a
+// short read marks it; a backup cut at a chunk boundary leaves an empty last chunk that fails to open.
+func (d *backupDecrypter) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		d.last = true
+	} else if err != nil {
+		return err
+	}
+
+	d.plain, err = d.gcm.Open(d.sealed[:0], chunkNonce(d.prefix, d.chunk, d.last), d.sealed[:n], nil)
+	if err != nil {
+		return errors.New("backup could not be decrypted; it was modified, cut off or the key is wrong")
+	}
+	d.chunk++
+	return nil
+}
+
+// backupReadCipher returns the cipher for reading encrypted backups, or ErrBackupKeyMissing
+func backupReadCipher() (cipher.AEAD, error) {
+	key, err := backupKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrBackupKeyMissing
+	}
+	return backupCipher(key)
+}
+
+// DecryptBackup opens an encrypted backup. Backups written before encryption are returned as they are.
+func DecryptBackup(data []byte) ([]byte, error) {
+	if !isEncryptedBackup(data) {
+		return data, nil
+	}
+
+	gcm, err := backupReadCipher()
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, chunkedBackupHeader) {
+		decrypter, err := newBackupDecrypter(bytes.NewReader(data), gcm)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(decrypter)
+	}
+	sealed := data[len(encryptedBackupHeader):]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted backup is truncated")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("backup could not be decrypted; it was modified or the key is wrong")
+	}
+	return plaintext, nil
+}
+
+// readStoredBackup reads a backup's bytes as stored, encrypted or not
+func readStoredBackup(filename string) ([]byte, error) {
+	if IsManifestKey(filename) {
+		return nil, ErrObjectNotFound
+	}
+
+	reader, err := Backups.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// BackupExists reports whether a backup is stored under filename
+func BackupExists(filename string) bool {
+	if IsManifestKey(filename) {
+		return false
+	}
+	reader, err := Backups.Get(filename)
+	if err != nil {
+		return false
+	}
+	reader.Close()
+	return true
+}
+
+// IsBackupEncrypted reports whether a stored backup starts with an encrypted backup header
+func IsBackupEncrypted(filename string) (bool, error) {
+	reader, err := Backups.Get(filename)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	header := make([]byte, len(encryptedBackupHeader))
+	if _, err := io.ReadFull(reader, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return isEncryptedBackup(header), nil
+}
+
+// EncryptStoredBackup encrypts a backup written before backups were always encrypted, in place, and
+// updates its manifest to match
+func EncryptStoredBackup(filename string) error {
+	if IsManifestKey(filename) {
+		return ErrObjectNotFound
+	}
+	reader, err := Backups.Get(filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	plaintext := bufio.NewReader(reader)
+	if header, _ := plaintext.Peek(len(encryptedBackupHeader)); isEncryptedBackup(header) {
+		return ErrBackupEncrypted
+	}
+
+	manifest, err := GetBackupManifest(filename)
+	if err != nil {
+		return err
+	}
+
+	checksum, size, err := storeBackup(filename, nil, func(w io.Writer) error {
+		_, err := io.Copy(w, plaintext)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Backups made before manifests existed are left without one
+	if manifest == nil {
+		return nil
+	}
+	manifest.SHA256 = checksum
+	manifest.Size = size
+	manifest.Encrypted = true
+	return saveBackupManifest(*manifest)
+}
+
+// OpenBackup opens a stored backup for reading its plaintext. Chunked backups are decrypted as they are
+// read; legacy backups sealed whole are decrypted in memory. The caller closes the reader.
+func OpenBackup(filename string) (io.ReadCloser, error) {
+	if IsManifestKey(filename) {
+		return nil, ErrObjectNotFound
+	}
+	reader, err := Backups.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(reader)
+	header, _ := buffered.Peek(len(chunkedBackupHeader))
+	switch {
+	case bytes.Equal(header, chunkedBackupHeader):
+		gcm, err := backupReadCipher()
+		if err == nil {
+			var decrypter io.Reader
+			if decrypter, err = newBackupDecrypter(buffered, gcm); err == nil {
+				return struct {
+					io.Reader
+					io.Closer
+				}{decrypter, reader}, nil
+			}
+		}
+		reader.Close()
+		return nil, err
+
+	case bytes.Equal(header, encryptedBackupHeader):
+		data, err := io.ReadAll(buffered)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := DecryptBackup(data)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(plaintext)), nil
+
+	default:
+		return struct {
+			io.Reader
+			io.Closer
+		}{buffered, reader}, nil
+	}
+}
+
+// ReadBackup reads and decrypts a stored backup workbook
+func ReadBackup(filename string) ([]byte, error) {
+	reader, err := OpenBackup(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func backupDownloadExpiry() time.Duration {
+	expiry := 5 * time.Minute
+	if revel.Config != nil {
+		if value, ok := revel.Config.String("backup.download.expiry"); ok {
+			if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+				expiry = parsed
+			}
+		}
+	}
+	return expiry
+}
+
+// backupDownloadKey is derived from the per-process admin token secret, so download links and admin
+// tokens never share a signing key
+var backupDownloadKey = sync.OnceValue(func() []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(jwtSecret), nil, []byte("backup-download")), key); err != nil {
+		panic(err)
+	}
+	return key
+})
+
+func signBackupDownload(filename string, expires int64) string {
+	mac := hmac.New(sha256.New, backupDownloadKey())
+	fmt.Fprintf(mac, "backup-download\n%s\n%d", filename, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BackupDownloadURL returns a signed link to download a backup, valid for backup.download.expiry.
+// Links are signed with a key derived from the per-process admin token secret, so a restart revokes them.
+func BackupDownloadURL(filename string) (string, time.Time) {
+	expiresAt := time.Now().Add(backupDownloadExpiry()).Truncate(time.Second)
+	query := url.Values{
+		"filename":  {filename},
+		"expires":   {fmt.Sprintf("%d", expiresAt.Unix())},
+		"signature": {signBackupDownload(filename, expiresAt.Unix())},
+	}
+	return "/api/download-backup?" + query.Encode(), expiresAt
+}
+
+// ValidBackupDownload reports whether a download link was signed by BackupDownloadURL and has not expired
+func ValidBackupDownload(filename string, expires int64, signature string) bool {
+	if filename == "" || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(signBackupDownload(filename, expires)))
+}
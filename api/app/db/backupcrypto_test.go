@@ -0,0 +1,345 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// useBackupKey sets BACKUP_ENCRYPTION_KEY for one test and makes backupKey read it again
+func useBackupKey(t *testing.T, value string) {
+	t.Helper()
+	t.Setenv("BACKUP_ENCRYPTION_KEY", value)
+	resetBackupKey()
+	t.Cleanup(resetBackupKey)
+}
+
+func resetBackupKey() {
+	backupKeyOnce.Once = sync.Once{}
+	backupKeyOnce.key = nil
+	backupKeyOnce.err = nil
+}
+
+func randomKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// encryptBackup seals plaintext the way storeBackup does, writing it writeSize bytes at a time
+func encryptBackup(t *testing.T, plaintext []byte, writeSize int) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	encrypter, err := newBackupEncrypter(&sealed)
+	if err != nil {
+		t.Fatalf("newBackupEncrypter: %v", err)
+	}
+	for rest := plaintext; len(rest) > 0; {
+		n := min(writeSize, len(rest))
+		if _, err := encrypter.Write(rest[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if err := encrypter.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return sealed.Bytes()
+}
+
+func TestBackupEncryptionRoundTrip(t *testing.T) {
+	useBackupKey(t, base64.StdEncoding.EncodeToString(randomKey(t)))
+
+	tests := []struct {
+		name      string
+		size      int
+		writeSize int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"under one chunk", backupChunkSize - 1, 4096},
+		{"exactly one chunk", backupChunkSize, backupChunkSize},
+		{"one chunk and a byte", backupChunkSize + 1, 1000},
+		{"several chunks in one write", 3*backupChunkSize + 17, 4 * backupChunkSize},
+		{"exactly several chunks", 2 * backupChunkSize, 333},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := make([]byte, tt.size)
+			rand.Read(plaintext)
+
+			sealed := encryptBackup(t, plaintext, tt.writeSize)
+			if !bytes.HasPrefix(sealed, chunkedBackupHeader) || !isEncryptedBackup(sealed) {
+				t.Fatal("sealed backup does not start with the chunked header")
+			}
+			if tt.size > 64 && bytes.Contains(sealed, plaintext[:64]) {
+				t.Fatal("sealed backup contains the plaintext")
+			}
+
+			opened, err := DecryptBackup(sealed)
+			if err != nil {
+				t.Fatalf("DecryptBackup: %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Fatalf("decrypted %d bytes that differ from the %d encrypted", len(opened), len(plaintext))
+			}
+		})
+	}
+}
+
+func TestBackupDecryptionDetectsTampering(t *testing.T) {
+	useBackupKey(t, hex.EncodeToString(randomKey(t)))
+
+	plaintext := make([]byte, 2*backupChunkSize+100)
+	rand.Read(plaintext)
+	sealed := encryptBackup(t, plaintext, len(plaintext))
+
+	headerSize := len(chunkedBackupHeader) + backupNoncePrefix
+	chunkSize := backupChunkSize + 16
+	chunks := [][]byte{
+		sealed[headerSize : headerSize+chunkSize],
+		sealed[headerSize+chunkSize : headerSize+2*chunkSize],
+		sealed[headerSize+2*chunkSize:],
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{sealed[:headerSize]}, parts...), nil)
+	}
+
+	tests := []struct {
+		name     string
+		modified []byte
+	}{
+		{"flipped bit", func() []byte {
+			modified := bytes.Clone(sealed)
+			modified[headerSize+chunkSize+10] ^= 1
+			return modified
+		}()},
+		{"changed nonce prefix", func() []byte {
+			modified := bytes.Clone(sealed)
+			modified[len(chunkedBackupHeader)] ^= 1
+			return modified
+		}()},
+		{"cut inside the last chunk", sealed[:len(sealed)-10]},
+		{"cut at a chunk boundary", join(chunks[0], chunks[1])},
+		{"chunks reordered", join(chunks[1], chunks[0], chunks[2])},
+		{"chunk dropped", join(chunks[0], chunks[2])},
+		{"header only", sealed[:len(chunkedBackupHeader)]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecryptBackup(tt.modified); err == nil {
+				t.Error("modified backup decrypted without error")
+			}
+		})
+	}
+
+	t.Run("wrong key", func(t *testing.T) {
+		useBackupKey(t, hex.EncodeToString(randomKey(t)))
+		if _, err := DecryptBackup(sealed); err == nil {
+			t.Error("backup decrypted with another key")
+		}
+	})
+}
+
+func TestDecryptLegacyBackup(t *testing.T) {
+	key := randomKey(t)
+	useBackupKey(t, base64.StdEncoding.EncodeToString(key))
+
+	gcm, err := backupCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	plaintext := []byte("PK\x03\x04 workbook sealed whole")
+	legacy := append(append(bytes.Clone(encryptedBackupHeader), nonce...), gcm.Seal(nil, nonce, plaintext, nil)...)
+
+	opened, err := DecryptBackup(legacy)
+	if err != nil {
+		t.Fatalf("DecryptBackup: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("decrypted %q, want %q", opened, plaintext)
+	}
+
+	legacy[len(legacy)-1] ^= 1
+	if _, err := DecryptBackup(legacy); err == nil {
+		t.Error("modified legacy backup decrypted without error")
+	}
+	if _, err := DecryptBackup(legacy[:len(encryptedBackupHeader)+4]); err == nil {
+		t.Error("truncated legacy backup decrypted without error")
+	}
+}
+
+func TestUnencryptedBackupPassesThrough(t *testing.T) {
+	useBackupKey(t, "")
+
+	plaintext := []byte("PK\x03\x04 workbook written before encryption")
+	opened, err := DecryptBackup(plaintext)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("DecryptBackup returned %q, %v; want the data unchanged", opened, err)
+	}
+}
+
+func TestBackupKeyRequired(t *testing.T) {
+	useBackupKey(t, base64.StdEncoding.EncodeToString(randomKey(t)))
+	sealed := encryptBackup(t, []byte("workbook"), 8)
+
+	useBackupKey(t, "")
+	if _, err := newBackupEncrypter(&bytes.Buffer{}); !errors.Is(err, ErrBackupKeyNotSet) {
+		t.Errorf("newBackupEncrypter without a key returned %v, want ErrBackupKeyNotSet", err)
+	}
+	if _, err := DecryptBackup(sealed); !errors.Is(err, ErrBackupKeyMissing) {
+		t.Errorf("DecryptBackup without a key returned %v, want ErrBackupKeyMissing", err)
+	}
+}
+
+func TestBackupKeyFormats(t *testing.T) {
+	key := randomKey(t)
+
+	tests := []struct {
+		name    string
+		value   string
+		wantKey bool
+		wantErr bool
+	}{
+		{"unset", "", false, false},
+		{"base64", base64.StdEncoding.EncodeToString(key), true, false},
+		{"hex", hex.EncodeToString(key), true, false},
+		{"too short", base64.StdEncoding.EncodeToString(key[:16]), false, true},
+		{"not encoded", "correct horse battery staple", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBackupKey(t, tt.value)
+			got, err := backupKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("backupKey returned error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantKey && !bytes.Equal(got, key) {
+				t.Errorf("backupKey returned %x, want %x", got, key)
+			}
+			if !tt.wantKey && got != nil {
+				t.Errorf("backupKey returned %x, want none", got)
+			}
+		})
+	}
+}
+
+func TestOpenBackupStreamsPlaintext(t *testing.T) {
+	key := randomKey(t)
+	useBackupKey(t, base64.StdEncoding.EncodeToString(key))
+
+	previous := Backups
+	Backups = &LocalStorage{Dir: t.TempDir()}
+	t.Cleanup(func() { Backups = previous })
+
+	plaintext := make([]byte, 2*backupChunkSize+5)
+	rand.Read(plaintext)
+
+	gcm, err := backupCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	stored := map[string][]byte{
+		"chunked.xlsx":   encryptBackup(t, plaintext, 4096),
+		"legacy.xlsx":    append(append(bytes.Clone(encryptedBackupHeader), nonce...), gcm.Seal(nil, nonce, plaintext, nil)...),
+		"plaintext.xlsx": plaintext,
+	}
+	for name, data := range stored {
+		if err := Backups.Put(name, bytes.NewReader(data), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name := range stored {
+		t.Run(name, func(t *testing.T) {
+			reader, err := OpenBackup(name)
+			if err != nil {
+				t.Fatalf("OpenBackup: %v", err)
+			}
+			defer reader.Close()
+
+			// Small reads cross chunk boundaries
+			got, err := io.ReadAll(iotest.OneByteReader(reader))
+			if err != nil {
+				t.Fatalf("reading: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Fatalf("read %d bytes that differ from the %d stored", len(got), len(plaintext))
+			}
+		})
+	}
+
+	t.Run("modified chunk", func(t *testing.T) {
+		modified := bytes.Clone(stored["chunked.xlsx"])
+		modified[len(modified)-1] ^= 1
+		if err := Backups.Put("modified.xlsx", bytes.NewReader(modified), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := OpenBackup("modified.xlsx")
+		if err != nil {
+			t.Fatalf("OpenBackup: %v", err)
+		}
+		defer reader.Close()
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Error("modified backup was read without error")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := OpenBackup("missing.xlsx"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("OpenBackup returned %v, want ErrObjectNotFound", err)
+		}
+	})
+}
+
+func TestBackupDownloadSignature(t *testing.T) {
+	expires := time.Now().Add(time.Minute).Unix()
+	signature := signBackupDownload("backup.xlsx", expires)
+
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	fmt.Fprintf(mac, "backup-download\n%s\n%d", "backup.xlsx", expires)
+	if signature == hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatal("download links are signed with the admin token secret")
+	}
+
+	tests := []struct {
+		name      string
+		filename  string
+		expires   int64
+		signature string
+		want      bool
+	}{
+		{"valid", "backup.xlsx", expires, signature, true},
+		{"other file", "other.xlsx", expires, signature, false},
+		{"extended expiry", "backup.xlsx", expires + 60, signature, false},
+		{"expired", "backup.xlsx", time.Now().Add(-time.Second).Unix(), signBackupDownload("backup.xlsx", time.Now().Add(-time.Second).Unix()), false},
+		{"no filename", "", expires, signBackupDownload("", expires), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidBackupDownload(tt.filename, tt.expires, tt.signature); got != tt.want {
+				t.Errorf("ValidBackupDownload returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
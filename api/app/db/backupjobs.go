@@ -0,0 +1,154 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// Stages of a backup job
+const (
+	BackupJobExporting = "exporting"
+	BackupJobSaving    = "saving"
+	BackupJobCompleted = "completed"
+	BackupJobFailed    = "failed"
+)
+
+// Most recent jobs kept for status lookups
+const maxBackupJobs = 20
+
+// ErrBackupInProgress is returned when a backup is requested while another is still being generated
+var ErrBackupInProgress = errors.New("a backup is already being generated")
+
+var backupJobs = struct {
+	sync.Mutex
+	jobs    map[string]*models.BackupJob
+	order   []string
+	running string
+}{jobs: map[string]*models.BackupJob{}}
+
+// exportProgress reports a backup's progress to its job. Exports run without a job, such as scheduled
+// backups, use a nil *exportProgress, which reports nothing.
+type exportProgress struct {
+	job *models.BackupJob
+}
+
+func (p *exportProgress) update(fn func(job *models.BackupJob)) {
+	if p == nil {
+		return
+	}
+	backupJobs.Lock()
+	defer backupJobs.Unlock()
+	fn(p.job)
+}
+
+func (p *exportProgress) setStatus(status string) {
+	p.update(func(job *models.BackupJob) {
+		job.Status = status
+		job.CurrentSection = ""
+	})
+}
+
+func (p *exportProgress) startSection(name string) {
+	p.update(func(job *models.BackupJob) { job.CurrentSection = name })
+}
+
+func (p *exportProgress) addRows(n int) {
+	p.update(func(job *models.BackupJob) { job.RowsWritten += n })
+}
+
+func (p *exportProgress) finishSection() {
+	p.update(func(job *models.BackupJob) { job.SectionsDone++ })
+}
+
+func newBackupJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// StartBackupJob generates a manual backup in the background and returns its job, whose progress
+// GetBackupJob reports. Only one job runs at a time; while one is running, it is returned with
+// ErrBackupInProgress. Without a backup key no job is started and ErrBackupKeyNotSet is returned.
+func StartBackupJob(username, format string) (models.BackupJob, error) {
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		return models.BackupJob{}, ErrUnknownFormat
+	}
+	if _, err := requireBackupKey(); err != nil {
+		return models.BackupJob{}, err
+	}
+
+	id, err := newBackupJobID()
+	if err != nil {
+		return models.BackupJob{}, err
+	}
+
+	backupJobs.Lock()
+	if running, ok := backupJobs.jobs[backupJobs.running]; ok {
+		job := *running
+		backupJobs.Unlock()
+		return job, ErrBackupInProgress
+	}
+
+	job := &models.BackupJob{
+		ID:            id,
+		Status:        BackupJobExporting,
+		Format:        format,
+		StartedBy:     username,
+		StartedAt:     time.Now().Format(time.RFC3339),
+		SectionsTotal: exportFormat.Sections,
+	}
+	backupJobs.jobs[id] = job
+	backupJobs.order = append(backupJobs.order, id)
+	backupJobs.running = id
+	if len(backupJobs.order) > maxBackupJobs {
+		delete(backupJobs.jobs, backupJobs.order[0])
+		backupJobs.order = backupJobs.order[1:]
+	}
+	started := *job
+	backupJobs.Unlock()
+
+	go runBackupJob(job, username, format)
+
+	return started, nil
+}
+
+func runBackupJob(job *models.BackupJob, username, format string) {
+	filename, err := generateBackup(BackupManual, username, format, &exportProgress{job: job})
+
+	backupJobs.Lock()
+	defer backupJobs.Unlock()
+
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+	job.CurrentSection = ""
+	if err != nil {
+		revel.AppLog.Errorf("Failed to generate backup: %v", err)
+		job.Status = BackupJobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = BackupJobCompleted
+		job.Filename = filename
+	}
+	backupJobs.running = ""
+}
+
+// GetBackupJob reports the progress of a backup job started by StartBackupJob
+func GetBackupJob(id string) (models.BackupJob, bool) {
+	backupJobs.Lock()
+	defer backupJobs.Unlock()
+
+	job, ok := backupJobs.jobs[id]
+	if !ok {
+		return models.BackupJob{}, false
+	}
+	return *job, true
+}
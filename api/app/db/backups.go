@@ -0,0 +1,201 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/revel/revel"
+	"github.com/xuri/excelize/v2"
+)
+
+// What started a backup
+const (
+	BackupManual    = "manual"
+	BackupScheduled = "scheduled"
+	BackupClose     = "close"
+)
+
+// Automatic backups carry this marker in their name; retention only ever deletes these
+const autoBackupMarker = " (auto)"
+
+// GenerateBackup exports every election table in the given format to backup storage, with a manifest
+// beside it, and returns the file's name. The outcome is recorded for the backup schedule status.
+func GenerateBackup(trigger, username, format string) (string, error) {
+	return generateBackup(trigger, username, format, nil)
+}
+
+func generateBackup(trigger, username, format string, progress *exportProgress) (string, error) {
+	filename, err := writeBackup(trigger, username, format, progress)
+	recordBackupRun(trigger, filename, err)
+	return filename, err
+}
+
+func writeBackup(trigger, username, format string, progress *exportProgress) (string, error) {
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		return "", ErrUnknownFormat
+	}
+
+	year := time.Now().Year()
+	nextYear := year + 1
+	currentTime := time.Now().Format("January 02 15-04")
+	filename := fmt.Sprintf("A.Y. %d-%d %s%s", year, nextYear, currentTime, exportFormat.Extension)
+	if trigger != BackupManual {
+		filename = fmt.Sprintf("A.Y. %d-%d %s%s%s", year, nextYear, currentTime, autoBackupMarker, exportFormat.Extension)
+	}
+
+	var rowCounts map[string]int
+	checksum, size, err := storeBackup(filename, progress, func(w io.Writer) error {
+		var err error
+		rowCounts, err = exportFormat.Render(w, progress)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	manifest := newBackupManifest(filename, checksum, size, trigger, username, rowCounts)
+	manifest.Encrypted = true
+	manifest.Format = format
+
+	// A backup without its manifest could not be verified, so it is not kept
+	if err := saveBackupManifest(manifest); err != nil {
+		if err := Backups.Delete(filename); err != nil {
+			revel.AppLog.Errorf("Failed to delete backup %s without a manifest: %v", filename, err)
+		}
+		return "", fmt.Errorf("failed to save backup manifest: %w", err)
+	}
+
+	return filename, nil
+}
+
+// storeBackup encrypts what write produces into a temporary file and then stores that file under
+// filename, so an export is never held in memory whole. It returns the SHA-256 and size of the stored
+// file; the checksum covers the encrypted bytes, so it can be checked without the key.
+func storeBackup(filename string, progress *exportProgress, write func(w io.Writer) error) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "backup-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	hash := sha256.New()
+	encrypter, err := newBackupEncrypter(io.MultiWriter(tmp, hash))
+	if err != nil {
+		return "", 0, err
+	}
+	if err := write(encrypter); err != nil {
+		return "", 0, err
+	}
+	if err := encrypter.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to encrypt backup file: %w", err)
+	}
+
+	progress.setStatus(BackupJobSaving)
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = Backups.Put(filename, tmp, "application/octet-stream")
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to save backup file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// renderXLSX writes each export sheet to a workbook. Rows go through excelize's stream writer, which
+// spills large sheets to a temporary file instead of building every cell in memory.
+func renderXLSX(w io.Writer, progress *exportProgress) (map[string]int, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	rowCounts := map[string]int{}
+
+	for i, sheet := range exportSheets {
+		progress.startSection(sheet.Name)
+
+		// The first sheet takes the place of the default "Sheet1"
+		if i == 0 {
+			if err := f.SetSheetName(f.GetSheetName(0), sheet.Name); err != nil {
+				return nil, fmt.Errorf("failed to create sheet %s: %w", sheet.Name, err)
+			}
+		} else if _, err := f.NewSheet(sheet.Name); err != nil {
+			return nil, fmt.Errorf("failed to create sheet %s: %w", sheet.Name, err)
+		}
+
+		rows, err := streamSheet(f, sheet.Name, sheet.Stream, progress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s sheet: %w", sheet.Name, err)
+		}
+		rowCounts[sheet.Name] = max(rows-1, 0)
+
+		progress.finishSection()
+	}
+
+	// The last sheet is the active one, as before sheets were streamed
+	f.SetActiveSheet(len(exportSheets) - 1)
+
+	if err := f.Write(w); err != nil {
+		return nil, fmt.Errorf("failed to write backup file: %w", err)
+	}
+	return rowCounts, nil
+}
+
+// streamSheet writes a sheet's rows as they are read and returns how many were written, header included
+func streamSheet(f *excelize.File, sheetName string, stream func(emit func([]string) error) error, progress *exportProgress) (int, error) {
+	writer, err := f.NewStreamWriter(sheetName)
+	if err != nil {
+		return 0, err
+	}
+
+	rowIndex := 0
+	err = stream(func(row []string) error {
+		rowIndex++
+		cells := make([]interface{}, len(row))
+		for colIndex, cell := range row {
+			// Sanitize cell value
+			cell = SanitizeCellValue(cell)
+
+			// Check for empty data
+			if cell == "" {
+				cell = "[Empty]"
+			}
+
+			// Check for excessive cell size
+			if len(cell) > 32767 {
+				revel.AppLog.Warnf("Cell at %d,%d of sheet '%s' exceeds Excel's character limit (32,767): truncating", rowIndex, colIndex+1, sheetName)
+				cell = cell[:32767]
+			}
+
+			cells[colIndex] = cell
+		}
+
+		cellRef, _ := excelize.CoordinatesToCellName(1, rowIndex)
+		if err := writer.SetRow(cellRef, cells); err != nil {
+			return err
+		}
+		progress.addRows(1)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rowIndex == 0 {
+		if err := writer.SetRow("A1", []interface{}{noDataAvailable}); err != nil {
+			return 0, err
+		}
+	}
+
+	return rowIndex, writer.Flush()
+}
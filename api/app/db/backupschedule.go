@@ -0,0 +1,230 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// How often the scheduler checks whether a backup is due
+const backupScheduleTick = time.Minute
+
+// Most recent failures kept for the schedule status
+const maxBackupFailures = 10
+
+var backupSchedule = struct {
+	sync.Mutex
+	started             bool
+	wasOpen             bool
+	lastScheduledAt     time.Time
+	lastSuccessAt       time.Time
+	lastBackup          string
+	lastFailureAt       time.Time
+	lastError           string
+	consecutiveFailures int
+	failures            []models.BackupFailure
+}{}
+
+func backupScheduleEnabled() bool {
+	if revel.Config != nil {
+		return revel.Config.BoolDefault("backup.schedule.enabled", true)
+	}
+	return true
+}
+
+func backupScheduleInterval() time.Duration {
+	interval := 30 * time.Minute
+	if revel.Config != nil {
+		if value, ok := revel.Config.String("backup.schedule.interval"); ok {
+			if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+				interval = parsed
+			}
+		}
+	}
+	return interval
+}
+
+// backupRetention returns how many automatic backups are always kept, and for how many days the
+// newest backup of each day is kept (0 for indefinitely)
+func backupRetention() (int, int) {
+	if revel.Config != nil {
+		return revel.Config.IntDefault("backup.retention.keep", 10), revel.Config.IntDefault("backup.retention.days", 30)
+	}
+	return 10, 30
+}
+
+// StartBackupScheduler takes a backup every backup.schedule.interval while the election is open, and
+// one more when it closes
+func StartBackupScheduler() {
+	if !backupScheduleEnabled() {
+		revel.AppLog.Info("Scheduled backups are disabled.")
+		return
+	}
+
+	backupSchedule.Lock()
+	if backupSchedule.started {
+		backupSchedule.Unlock()
+		return
+	}
+	backupSchedule.started = true
+	backupSchedule.wasOpen = ElectionOpen()
+	backupSchedule.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(backupScheduleTick)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runScheduledBackup(now)
+		}
+	}()
+}
+
+func runScheduledBackup(now time.Time) {
+	open := ElectionOpen()
+
+	backupSchedule.Lock()
+	trigger := ""
+	switch {
+	case open && now.Sub(backupSchedule.lastScheduledAt) >= backupScheduleInterval():
+		trigger = BackupScheduled
+	case !open && backupSchedule.wasOpen:
+		trigger = BackupClose
+	}
+	backupSchedule.wasOpen = open
+	if trigger != "" {
+		backupSchedule.lastScheduledAt = now
+	}
+	backupSchedule.Unlock()
+
+	if trigger == "" {
+		return
+	}
+
+	if _, err := GenerateBackup(trigger, "system", FormatXLSX); err != nil {
+		revel.AppLog.Errorf("Scheduled backup (%s) failed: %v", trigger, err)
+
+		// Try again on the next tick rather than waiting a full interval, and keep a missed
+		// closing backup pending. A missing key will not fix itself, so the interval is kept.
+		backupSchedule.Lock()
+		if err != ErrBackupKeyNotSet {
+			backupSchedule.lastScheduledAt = time.Time{}
+		}
+		if trigger == BackupClose {
+			backupSchedule.wasOpen = true
+		}
+		backupSchedule.Unlock()
+		return
+	}
+
+	if err := ApplyBackupRetention(); err != nil {
+		revel.AppLog.Errorf("Failed to apply backup retention: %v", err)
+	}
+}
+
+// recordBackupRun keeps the outcome of a backup for the schedule status
+func recordBackupRun(trigger, filename string, err error) {
+	backupSchedule.Lock()
+	defer backupSchedule.Unlock()
+
+	now := time.Now()
+	if err == nil {
+		backupSchedule.lastSuccessAt = now
+		backupSchedule.lastBackup = filename
+		backupSchedule.consecutiveFailures = 0
+		return
+	}
+
+	backupSchedule.lastFailureAt = now
+	backupSchedule.lastError = err.Error()
+	backupSchedule.consecutiveFailures++
+	backupSchedule.failures = append(backupSchedule.failures, models.BackupFailure{
+		At:      now.Format(time.RFC3339),
+		Trigger: trigger,
+		Error:   err.Error(),
+	})
+	if len(backupSchedule.failures) > maxBackupFailures {
+		backupSchedule.failures = backupSchedule.failures[len(backupSchedule.failures)-maxBackupFailures:]
+	}
+}
+
+// ApplyBackupRetention deletes automatic backups other than the newest backup.retention.keep and the
+// newest of each of the last backup.retention.days days. Backups an admin generated are never deleted.
+func ApplyBackupRetention() error {
+	objects, err := Backups.List()
+	if err != nil {
+		return err
+	}
+
+	var automatic []StoredObject
+	for _, object := range objects {
+		if strings.Contains(object.Key, autoBackupMarker) && !IsManifestKey(object.Key) {
+			automatic = append(automatic, object)
+		}
+	}
+	sort.Slice(automatic, func(i, j int) bool {
+		return automatic[i].ModTime.After(automatic[j].ModTime)
+	})
+
+	keepLast, keepDays := backupRetention()
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	days := map[string]bool{}
+
+	for i, object := range automatic {
+		day := object.ModTime.Local().Format("2006-01-02")
+		newestOfDay := !days[day]
+		days[day] = true
+
+		if i < keepLast || (newestOfDay && (keepDays == 0 || object.ModTime.After(cutoff))) {
+			continue
+		}
+		if err := DeleteBackup(object.Key); err != nil {
+			revel.AppLog.Errorf("Failed to delete expired backup %s: %v", object.Key, err)
+			continue
+		}
+		revel.AppLog.Infof("Deleted expired backup %s", object.Key)
+	}
+
+	return nil
+}
+
+// BackupScheduleStatus reports the backup schedule, retention policy and the latest outcomes
+func BackupScheduleStatus() models.BackupSchedule {
+	keepLast, keepDays := backupRetention()
+	open := ElectionOpen()
+
+	backupSchedule.Lock()
+	defer backupSchedule.Unlock()
+
+	status := models.BackupSchedule{
+		Enabled:             backupScheduleEnabled(),
+		Interval:            backupScheduleInterval().String(),
+		KeepLast:            keepLast,
+		KeepDays:            keepDays,
+		ElectionOpen:        open,
+		LastBackup:          backupSchedule.lastBackup,
+		LastError:           backupSchedule.lastError,
+		ConsecutiveFailures: backupSchedule.consecutiveFailures,
+		RecentFailures:      append([]models.BackupFailure{}, backupSchedule.failures...),
+	}
+
+	if status.Enabled && open {
+		next := backupSchedule.lastScheduledAt.Add(backupScheduleInterval())
+		if next.Before(time.Now()) {
+			next = time.Now().Add(backupScheduleTick).Truncate(time.Second)
+		}
+		status.NextBackupAt = next.Format(time.RFC3339)
+	}
+	if !backupSchedule.lastSuccessAt.IsZero() {
+		status.LastSuccessAt = backupSchedule.lastSuccessAt.Format(time.RFC3339)
+	}
+	if !backupSchedule.lastFailureAt.IsZero() {
+		status.LastFailureAt = backupSchedule.lastFailureAt.Format(time.RFC3339)
+	}
+
+	return status
+}
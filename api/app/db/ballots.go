@@ -0,0 +1,249 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"api/app/models"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+)
+
+const (
+	BallotAccepted       = "accepted"
+	BallotAlreadyCounted = "already_counted"
+	BallotRejected       = "rejected"
+)
+
+// MySQL error number for a duplicate primary or unique key
+const mysqlDuplicateEntry = 1062
+
+// BallotResult reports what happened to a single submitted ballot
+type BallotResult struct {
+	BallotID string `json:"ballot_id"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func rejectBallot(tx *sql.Tx, ballotID, reason string) (BallotResult, error) {
+	tx.Rollback()
+	return BallotResult{BallotID: ballotID, Status: BallotRejected, Reason: reason}, nil
+}
+
+// CastBallot records a ballot exactly once, keyed by its kiosk-generated ballot ID.
+// Replays of an already stored ballot report BallotAlreadyCounted; ballots that can never be
+// counted report BallotRejected. A non-nil error means a transient failure and the kiosk should retry.
+func CastBallot(kioskID string, vote models.Votes) (BallotResult, error) {
+	if vote.BallotID == "" {
+		vote.BallotID = uuid.NewString()
+	}
+	if _, err := uuid.Parse(vote.BallotID); err != nil {
+		return BallotResult{BallotID: vote.BallotID, Status: BallotRejected, Reason: "Invalid ballot ID"}, nil
+	}
+
+	department, err := IdentifyDepartment(vote.Program)
+	if err != nil {
+		return BallotResult{BallotID: vote.BallotID, Status: BallotRejected, Reason: "Invalid program specified"}, nil
+	}
+
+	var alreadyCounted int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM ballots WHERE ballot_id = ?`, vote.BallotID).Scan(&alreadyCounted); err != nil {
+		return BallotResult{}, err
+	}
+	if alreadyCounted > 0 {
+		return BallotResult{BallotID: vote.BallotID, Status: BallotAlreadyCounted}, nil
+	}
+
+	selections, counted, dropped, reason, err := normalizeSelections(vote, department)
+	if err != nil {
+		return BallotResult{}, err
+	}
+	if reason != "" {
+		return BallotResult{BallotID: vote.BallotID, Status: BallotRejected, Reason: reason}, nil
+	}
+	selectionsJSON, err := json.Marshal(selections)
+	if err != nil {
+		return BallotResult{}, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return BallotResult{}, err
+	}
+
+	// The ballot row is written first so a concurrent replay fails on the primary key
+	_, err = tx.Exec(`INSERT INTO ballots (ballot_id, kiosk_id, department, selections) VALUES (?, ?, ?, ?)`,
+		vote.BallotID, kioskID, department, string(selectionsJSON))
+	if err != nil {
+		tx.Rollback()
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return BallotResult{BallotID: vote.BallotID, Status: BallotAlreadyCounted}, nil
+		}
+		return BallotResult{}, err
+	}
+
+	var certified bool
+	err = tx.QueryRow(`SELECT certified_at IS NOT NULL FROM election_settings LIMIT 1`).Scan(&certified)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return BallotResult{}, err
+	}
+	if certified {
+		return rejectBallot(tx, vote.BallotID, "Election results are already certified")
+	}
+
+	var hasVoted bool
+	err = tx.QueryRow(`SELECT has_voted FROM voters WHERE student_id = ? FOR UPDATE`, vote.StudentID).Scan(&hasVoted)
+	if err == sql.ErrNoRows {
+		return rejectBallot(tx, vote.BallotID, "Student is not registered")
+	} else if err != nil {
+		tx.Rollback()
+		return BallotResult{}, err
+	}
+	if hasVoted {
+		return rejectBallot(tx, vote.BallotID, "Student has already voted")
+	}
+
+	var events []TallyEvent
+	for position, candidates := range counted {
+		for _, candidate := range candidates {
+			result, err := tx.Exec(`UPDATE votes SET `+department+` = `+department+` + 1 WHERE candidate_id = ?`, candidate.ID)
+			if err != nil {
+				tx.Rollback()
+				return BallotResult{}, err
+			}
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+				return rejectBallot(tx, vote.BallotID, "Unknown candidate "+candidate.PositionName)
+			}
+
+			event := TallyEvent{Position: position, CandidateID: candidate.ID, PositionName: candidate.PositionName, Department: department, Delta: 1}
+			err = tx.QueryRow(`SELECT total_votes FROM votes WHERE candidate_id = ?`, candidate.ID).Scan(&event.TotalVotes)
+			if err != nil {
+				tx.Rollback()
+				return BallotResult{}, err
+			}
+			events = append(events, event)
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE voters SET has_voted = TRUE WHERE student_id = ?`, vote.StudentID); err != nil {
+		tx.Rollback()
+		return BallotResult{}, err
+	}
+
+	// Selections of candidates who withdrew while the ballot was queued count as abstentions
+	for _, candidate := range dropped {
+		details := map[string]interface{}{"ballot_id": vote.BallotID, "position": candidate.Position, "candidate_id": candidate.ID}
+		if err := RecordAudit(tx, "kiosk:"+kioskID, "ballot.selection_dropped", candidate.PositionName, details); err != nil {
+			tx.Rollback()
+			return BallotResult{}, err
+		}
+	}
+
+	if err := RecordKioskBallot(tx, kioskID); err != nil {
+		tx.Rollback()
+		return BallotResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return BallotResult{}, err
+	}
+
+	InvalidateResults()
+	PublishTally(events)
+
+	return BallotResult{BallotID: vote.BallotID, Status: BallotAccepted}, nil
+}
+
+// normalizeSelections merges the legacy single-choice fields into the per-position selections and checks
+// every choice against the candidate list and the position's selection limit. Choices may name candidates
+// by ID or by their legacy position_name; the returned selections always hold IDs. Positions left empty are
+// abstentions. It also returns the candidates that add to the vote counters: every selection on plurality
+// positions, and only the first preference on ranked-choice positions. Board member positions are only on
+// the ballot of the department that elects them. Choices of withdrawn candidates are left out and returned
+// as dropped; a ranked-choice ballot's later preferences move up in their place. A non-empty reason means
+// the ballot must be rejected.
+func normalizeSelections(vote models.Votes, department string) (map[string][]string, map[string][]candidateRecord, []candidateRecord, string, error) {
+	index, err := loadCandidateIndex()
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	rules, err := positionRules()
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	chosen := make(map[string][]candidateRecord)
+	for position, refs := range vote.Selections {
+		for _, ref := range refs {
+			if ref == "" {
+				continue
+			}
+			candidate, ok := index.resolve(ref)
+			if !ok {
+				return nil, nil, nil, "Unknown candidate " + ref, nil
+			}
+			if candidate.Position != position {
+				return nil, nil, nil, candidate.PositionName + " is not a candidate for " + position, nil
+			}
+			chosen[position] = append(chosen[position], candidate)
+		}
+	}
+	for _, ref := range []string{vote.GovernorVote, vote.ViceGovernorVote, vote.BoardMemberVote} {
+		if ref == "" {
+			continue
+		}
+		candidate, ok := index.resolve(ref)
+		if !ok {
+			return nil, nil, nil, "Unknown candidate " + ref, nil
+		}
+		chosen[candidate.Position] = append(chosen[candidate.Position], candidate)
+	}
+
+	selections := make(map[string][]string)
+	counted := make(map[string][]candidateRecord)
+	var dropped []candidateRecord
+	for position, candidates := range chosen {
+		for _, column := range departmentColumns {
+			if column.BoardMember == position && column.Column != department {
+				return nil, nil, nil, position + " is not elected by " + vote.Program, nil
+			}
+		}
+
+		rule := ruleFor(rules, position)
+		if len(candidates) > rule.MaxSelections {
+			return nil, nil, nil, fmt.Sprintf("At most %d selection(s) allowed for %s", rule.MaxSelections, position), nil
+		}
+
+		seen := make(map[int64]bool)
+		var standing []candidateRecord
+		for _, candidate := range candidates {
+			if seen[candidate.ID] {
+				return nil, nil, nil, candidate.PositionName + " was selected more than once", nil
+			}
+			seen[candidate.ID] = true
+			if candidate.Withdrawn {
+				dropped = append(dropped, candidate)
+				continue
+			}
+			standing = append(standing, candidate)
+			selections[position] = append(selections[position], strconv.FormatInt(candidate.ID, 10))
+		}
+		if len(standing) == 0 {
+			continue
+		}
+
+		if rule.Tabulation == TabulationRankedChoice {
+			counted[position] = standing[:1]
+		} else {
+			counted[position] = standing
+		}
+	}
+
+	return selections, counted, dropped, "", nil
+}
@@ -0,0 +1,519 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"api/app/models"
+)
+
+var (
+	// ErrCandidateNotFound is returned when no candidate has the given position_name
+	ErrCandidateNotFound = errors.New("candidate not found")
+	// ErrCandidateLocked is wrapped by every reason a candidate cannot be changed in the current election state
+	ErrCandidateLocked = errors.New("candidate cannot be changed")
+)
+
+// candidateRecord identifies a candidate by its stable ID and by its legacy position_name key
+type candidateRecord struct {
+	ID           int64
+	PositionName string
+	Position     string
+	Withdrawn    bool
+}
+
+// candidateIndex resolves candidate references given either as IDs or as legacy position_names
+type candidateIndex struct {
+	byID           map[int64]candidateRecord
+	byPositionName map[string]candidateRecord
+}
+
+func loadCandidateIndex() (candidateIndex, error) {
+	index := candidateIndex{byID: make(map[int64]candidateRecord), byPositionName: make(map[string]candidateRecord)}
+
+	rows, err := DB.Query(`SELECT id, position_name, position, withdrawn_at IS NOT NULL FROM candidates`)
+	if err != nil {
+		return index, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record candidateRecord
+		if err := rows.Scan(&record.ID, &record.PositionName, &record.Position, &record.Withdrawn); err != nil {
+			return index, err
+		}
+		index.byID[record.ID] = record
+		index.byPositionName[record.PositionName] = record
+	}
+
+	return index, nil
+}
+
+// resolve accepts a candidate ID or, for clients and ballots predating IDs, a position_name
+func (index candidateIndex) resolve(ref string) (candidateRecord, bool) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		if record, ok := index.byID[id]; ok {
+			return record, true
+		}
+	}
+	record, ok := index.byPositionName[ref]
+	return record, ok
+}
+
+// ResolveCandidateID returns id when set, otherwise the ID of the candidate with the legacy position_name
+func ResolveCandidateID(id int64, positionName string) (int64, error) {
+	query, arg := `SELECT id FROM candidates WHERE position_name = ?`, interface{}(positionName)
+	if id != 0 {
+		query, arg = `SELECT id FROM candidates WHERE id = ?`, id
+	}
+
+	var candidateID int64
+	err := DB.QueryRow(query, arg).Scan(&candidateID)
+	if err == sql.ErrNoRows {
+		return 0, ErrCandidateNotFound
+	}
+	return candidateID, err
+}
+
+// LookupCandidate resolves a candidate reference from a URL or ballot, either a numeric ID or a legacy position_name
+func LookupCandidate(ref string) (int64, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		if candidateID, err := ResolveCandidateID(id, ""); err != ErrCandidateNotFound {
+			return candidateID, err
+		}
+	}
+	return ResolveCandidateID(0, ref)
+}
+
+// UniquePositionName builds the legacy position_name key for a new candidate, adding a numeric suffix when
+// another candidate for the same position already has the same name
+func UniquePositionName(q queryer, position, name string) (string, error) {
+	base := position + "_" + name
+	positionName := base
+	for n := 2; ; n++ {
+		var taken int
+		if err := q.QueryRow(`SELECT COUNT(*) FROM candidates WHERE position_name = ?`, positionName).Scan(&taken); err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return positionName, nil
+		}
+		positionName = fmt.Sprintf("%s_%d", base, n)
+	}
+}
+
+// VotingStarted reports whether ballots can no longer be edited freely: the election is open or has already received ballots
+func VotingStarted() (bool, error) {
+	if ElectionOpen() {
+		return true, nil
+	}
+
+	var ballots int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM ballots`).Scan(&ballots); err != nil {
+		return false, err
+	}
+	return ballots > 0, nil
+}
+
+// DeleteCandidate removes a candidate along with its credentials and vote row. It is only allowed before
+// voting starts; afterwards the candidate has to be withdrawn so the votes already cast are kept.
+// The candidate's photo and thumbnail file names are returned so the caller can remove the files.
+func DeleteCandidate(candidateID int64, username string) (string, string, error) {
+	started, err := VotingStarted()
+	if err != nil {
+		return "", "", err
+	}
+	if started {
+		return "", "", fmt.Errorf("%w: voting has started, withdraw the candidate instead", ErrCandidateLocked)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+
+	var positionName, name, position string
+	var photoURL, thumbnailURL sql.NullString
+	err = tx.QueryRow(`SELECT position_name, name, position, photo_url, thumbnail_url FROM candidates WHERE id = ? FOR UPDATE`, candidateID).
+		Scan(&positionName, &name, &position, &photoURL, &thumbnailURL)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return "", "", ErrCandidateNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return "", "", err
+	}
+
+	var totalVotes int
+	err = tx.QueryRow(`SELECT COALESCE(SUM(total_votes), 0) FROM votes WHERE candidate_id = ?`, candidateID).Scan(&totalVotes)
+	if err != nil {
+		tx.Rollback()
+		return "", "", err
+	}
+	if totalVotes > 0 {
+		tx.Rollback()
+		return "", "", fmt.Errorf("%w: the candidate already has votes, withdraw the candidate instead", ErrCandidateLocked)
+	}
+	// The votes row references the candidate, so it goes first
+	if _, err := tx.Exec(`DELETE FROM votes WHERE candidate_id = ?`, candidateID); err != nil {
+		tx.Rollback()
+		return "", "", err
+	}
+	if _, err := tx.Exec(`DELETE FROM candidates WHERE id = ?`, candidateID); err != nil {
+		tx.Rollback()
+		return "", "", err
+	}
+
+	details := map[string]interface{}{"id": candidateID, "name": name, "position": position, "photo_url": photoURL.String}
+	if err := RecordAudit(tx, username, "candidate.delete", positionName, details); err != nil {
+		tx.Rollback()
+		return "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	InvalidateResults()
+	return photoURL.String, thumbnailURL.String, nil
+}
+
+// WithdrawCandidate hides a candidate from the ballot while keeping the votes it has already received
+func WithdrawCandidate(candidateID int64, username, reason string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var positionName string
+	var withdrawn bool
+	err = tx.QueryRow(`SELECT position_name, withdrawn_at IS NOT NULL FROM candidates WHERE id = ? FOR UPDATE`, candidateID).Scan(&positionName, &withdrawn)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrCandidateNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if withdrawn {
+		tx.Rollback()
+		return fmt.Errorf("%w: the candidate has already withdrawn", ErrCandidateLocked)
+	}
+
+	if _, err := tx.Exec(`UPDATE candidates SET withdrawn_at = NOW() WHERE id = ?`, candidateID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := RecordAudit(tx, username, "candidate.withdraw", positionName, map[string]interface{}{"id": candidateID, "reason": reason}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	InvalidateResults()
+	return nil
+}
+
+// UpdateCandidate changes one candidate's details in a transaction, after the same validation as
+// SaveCandidates. Validation problems are returned as issues and nothing is written. A candidate's
+// position can only change before voting starts, since the votes it has received were cast for the
+// position it had.
+func UpdateCandidate(candidateID int64, candidate models.Candidate, username string) ([]models.CandidateIssue, error) {
+	candidate.Name = strings.TrimSpace(candidate.Name)
+	issues, err := ValidateCandidates([]models.CandidateSubmission{{Section: "candidate", Candidate: candidate}}, true)
+	if err != nil || len(issues) > 0 {
+		return issues, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var positionName, position string
+	err = tx.QueryRow(`SELECT position_name, position FROM candidates WHERE id = ? FOR UPDATE`, candidateID).Scan(&positionName, &position)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, ErrCandidateNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if candidate.Position != position {
+		started, err := VotingStarted()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if started || ElectionCertified() {
+			tx.Rollback()
+			return nil, fmt.Errorf("%w: voting has started, so the candidate's position can no longer change", ErrCandidateLocked)
+		}
+	}
+
+	var taken int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM candidates WHERE position = ? AND name = ? AND id <> ?`, candidate.Position, candidate.Name, candidateID).Scan(&taken)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if taken > 0 {
+		tx.Rollback()
+		return []models.CandidateIssue{{Section: "candidate", Name: candidate.Name,
+			Error: fmt.Sprintf("%s is already a candidate for %s", candidate.Name, candidate.Position)}}, nil
+	}
+
+	partylistID, partylist, err := ResolvePartylist(tx, candidate.PartylistID, candidate.Partylist)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE candidates SET name = ?, position = ?, year_level = ?, program = ?, partylist = ?, partylist_id = ? WHERE id = ?`,
+		candidate.Name, candidate.Position, candidate.YearLevel, candidate.Program, partylist, partylistID, candidateID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// The votes row is joined by ID, so only its display columns need to follow the candidate
+	if _, err := tx.Exec(`UPDATE votes SET name = ?, position = ? WHERE candidate_id = ?`, candidate.Name, candidate.Position, candidateID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	details := map[string]interface{}{"id": candidateID, "name": candidate.Name, "position": candidate.Position, "partylist": partylist}
+	if err := RecordAudit(tx, username, "candidate.update", positionName, details); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	InvalidateResults()
+	return nil, nil
+}
+
+// KnownPositions lists the positions candidates may run for: the executive positions, one board member
+// position per department, and any position configured in the positions table
+func KnownPositions() (map[string]bool, error) {
+	known := map[string]bool{"Governor": true, "Vice Governor": true}
+	for _, department := range departmentColumns {
+		known[department.BoardMember] = true
+	}
+
+	rules, err := positionRules()
+	if err != nil {
+		return nil, err
+	}
+	for position := range rules {
+		known[position] = true
+	}
+
+	return known, nil
+}
+
+// ValidateCandidates checks a whole submission before anything is written and returns every problem found.
+// Without upsert, a candidate that already exists for the same position is reported as a duplicate.
+func ValidateCandidates(submissions []models.CandidateSubmission, upsert bool) ([]models.CandidateIssue, error) {
+	known, err := KnownPositions()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := positionRules()
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []models.CandidateIssue{}
+	seen := make(map[string]bool)
+	partylistSeats := make(map[string]int)
+
+	for _, submission := range submissions {
+		candidate := submission.Candidate
+		report := func(format string, args ...interface{}) {
+			issues = append(issues, models.CandidateIssue{
+				Section: submission.Section,
+				Index:   submission.Index,
+				Name:    candidate.Name,
+				Error:   fmt.Sprintf(format, args...),
+			})
+		}
+
+		name := strings.TrimSpace(candidate.Name)
+		if name == "" || candidate.Position == "" || strings.TrimSpace(candidate.Program) == "" {
+			report("name, position and program are required")
+			continue
+		}
+		if !known[candidate.Position] {
+			report("unknown position %s", candidate.Position)
+			continue
+		}
+
+		// Board members must come from the department that elects them, so their program has to be one
+		// of the official names; other positions are open to any program
+		for _, department := range departmentColumns {
+			if department.BoardMember != candidate.Position {
+				continue
+			}
+			if column, err := IdentifyDepartment(candidate.Program); err != nil {
+				report("unknown program %s", candidate.Program)
+			} else if column != department.Column {
+				report("%s is not in the department that elects %s", candidate.Program, candidate.Position)
+			}
+		}
+
+		key := candidate.Position + "\x00" + strings.ToLower(name)
+		if seen[key] {
+			report("%s is listed more than once for %s", name, candidate.Position)
+		}
+		seen[key] = true
+
+		if !upsert {
+			var existing int
+			err := DB.QueryRow(`SELECT COUNT(*) FROM candidates WHERE position = ? AND name = ?`, candidate.Position, name).Scan(&existing)
+			if err != nil {
+				return nil, err
+			}
+			if existing > 0 {
+				report("%s is already a candidate for %s; resubmit with upsert to update", name, candidate.Position)
+			}
+		}
+
+		partylist, err := submittedPartylist(candidate)
+		if err != nil {
+			report("%v", err)
+			continue
+		}
+		if partylist != "" {
+			seatKey := candidate.Position + "\x00" + partylist
+			partylistSeats[seatKey]++
+			if seats := ruleFor(rules, candidate.Position).Seats; partylistSeats[seatKey] == seats+1 {
+				report("%s fields more than %d candidate(s) for %s", partylist, seats, candidate.Position)
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// submittedPartylist checks that a candidate's partylist ID exists and agrees with its partylist name, and
+// returns the name the candidate will be stored under ("" for independents)
+func submittedPartylist(candidate models.Candidate) (string, error) {
+	name := strings.TrimSpace(candidate.Partylist)
+	if candidate.PartylistID == nil {
+		if strings.EqualFold(name, IndependentPartylist) {
+			return "", nil
+		}
+		return name, nil
+	}
+
+	var stored, acronym string
+	err := DB.QueryRow(`SELECT name, COALESCE(acronym, '') FROM partylists WHERE id = ?`, *candidate.PartylistID).Scan(&stored, &acronym)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("partylist %d does not exist", *candidate.PartylistID)
+	} else if err != nil {
+		return "", err
+	}
+	if name != "" && !strings.EqualFold(name, stored) && !strings.EqualFold(name, acronym) {
+		return "", fmt.Errorf("partylist %q does not match partylist %d (%s)", name, *candidate.PartylistID, stored)
+	}
+	return stored, nil
+}
+
+// SaveCandidates writes a validated submission in one transaction: the partylists sent with it, each candidate,
+// and the candidate's vote counter row. Candidates must name a partylist that is stored or sent with them.
+// With upsert, candidates matching an existing position and name are updated instead of inserted.
+func SaveCandidates(partylists []models.Partylist, submissions []models.CandidateSubmission, upsert bool, username string) (models.CandidateSaveResult, error) {
+	var result models.CandidateSaveResult
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	for _, partylist := range partylists {
+		if _, err := MergePartylist(tx, partylist); err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	for _, submission := range submissions {
+		candidate := submission.Candidate
+		candidate.Name = strings.TrimSpace(candidate.Name)
+
+		partylistID, partylist, err := ResolvePartylist(tx, candidate.PartylistID, candidate.Partylist)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+
+		var candidateID int64
+		var positionName string
+		err = tx.QueryRow(`SELECT id, position_name FROM candidates WHERE position = ? AND name = ? FOR UPDATE`, candidate.Position, candidate.Name).
+			Scan(&candidateID, &positionName)
+		switch {
+		case err == sql.ErrNoRows:
+			positionName, err = UniquePositionName(tx, candidate.Position, candidate.Name)
+			if err != nil {
+				tx.Rollback()
+				return result, err
+			}
+			inserted, err := tx.Exec(`INSERT INTO candidates (position_name, name, position, year_level, program, partylist, partylist_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				positionName, candidate.Name, candidate.Position, candidate.YearLevel, candidate.Program, partylist, partylistID)
+			if err != nil {
+				tx.Rollback()
+				return result, err
+			}
+			candidateID, _ = inserted.LastInsertId()
+			result.Created++
+		case err != nil:
+			tx.Rollback()
+			return result, err
+		case !upsert:
+			// Validation already refused duplicates; this only happens when another submission raced this one
+			tx.Rollback()
+			return result, fmt.Errorf("%s is already a candidate for %s", candidate.Name, candidate.Position)
+		default:
+			_, err = tx.Exec(`UPDATE candidates SET year_level = ?, program = ?, partylist = ?, partylist_id = ? WHERE id = ?`,
+				candidate.YearLevel, candidate.Program, partylist, partylistID, candidateID)
+			if err != nil {
+				tx.Rollback()
+				return result, err
+			}
+			result.Updated++
+		}
+
+		// Every candidate needs a counter row before the first ballot can be counted for them
+		_, err = tx.Exec(`INSERT INTO votes (position_name, candidate_id, name, position) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE name = VALUES(name), position = VALUES(position)`,
+			positionName, candidateID, candidate.Name, candidate.Position)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	if err := RecordAudit(tx, username, "candidate.bulk_save", "", result); err != nil {
+		tx.Rollback()
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+
+	InvalidateResults()
+	return result, nil
+}
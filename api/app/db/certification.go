@@ -0,0 +1,327 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// ErrCertificationRefused is wrapped by every reason an election cannot be certified yet
+var ErrCertificationRefused = errors.New("certification refused")
+
+// ElectionCertified reports whether the current election results have been certified and locked
+func ElectionCertified() bool {
+	var certified bool
+	err := DB.QueryRow(`SELECT certified_at IS NOT NULL FROM election_settings LIMIT 1`).Scan(&certified)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			revel.AppLog.Errorf("Failed to fetch certification status: %v", err)
+		}
+		return false
+	}
+	return certified
+}
+
+// certificationKey loads the Ed25519 signing key from CERTIFICATION_KEY (a base64 encoded 32 byte seed)
+func certificationKey() (ed25519.PrivateKey, error) {
+	encoded := os.Getenv("CERTIFICATION_KEY")
+	if encoded == "" {
+		return nil, fmt.Errorf("%w: CERTIFICATION_KEY is not configured", ErrCertificationRefused)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: CERTIFICATION_KEY must be a base64 encoded %d byte seed", ErrCertificationRefused, ed25519.SeedSize)
+	}
+
+	key := ed25519.NewKeyFromSeed(seed)
+	if configured, err := certificationPublicKey(); err == nil && !configured.Equal(key.Public()) {
+		return nil, fmt.Errorf("%w: CERTIFICATION_PUBLIC_KEY does not belong to CERTIFICATION_KEY", ErrCertificationRefused)
+	}
+	return key, nil
+}
+
+// certificationPublicKey loads the key canvass records are verified against: CERTIFICATION_PUBLIC_KEY
+// (base64 encoded), or else the public half of CERTIFICATION_KEY. The key stored with a record is only
+// compared with it, since whoever can rewrite a record can also replace its stored key.
+func certificationPublicKey() (ed25519.PublicKey, error) {
+	if encoded := os.Getenv("CERTIFICATION_PUBLIC_KEY"); encoded != "" {
+		publicKey, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("CERTIFICATION_PUBLIC_KEY must be a base64 encoded %d byte key", ed25519.PublicKeySize)
+		}
+		return publicKey, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(os.Getenv("CERTIFICATION_KEY"))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("neither CERTIFICATION_PUBLIC_KEY nor a valid CERTIFICATION_KEY is configured")
+	}
+	return ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), nil
+}
+
+// ballotsDigest hashes every stored ballot in ballot ID order
+func ballotsDigest() (string, int, error) {
+	rows, err := DB.Query(`SELECT ballot_id, department, selections FROM ballots ORDER BY ballot_id`)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rows.Close()
+
+	hash := sha256.New()
+	count := 0
+	for rows.Next() {
+		var ballotID, department, selections string
+		if err := rows.Scan(&ballotID, &department, &selections); err != nil {
+			return "", 0, err
+		}
+		fmt.Fprintf(hash, "%s|%s|%s\n", ballotID, department, selections)
+		count++
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), count, rows.Err()
+}
+
+func canvassCounts() ([]models.ExcelVotes, error) {
+	rows, err := DB.Query(`SELECT position_name, name, position, coe_votes, cba_votes, cics_votes, cit_votes, coed_bsed, coed_beed, coed_bped, coed_votes, total_votes FROM votes ORDER BY position, position_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.ExcelVotes{}
+	for rows.Next() {
+		var v models.ExcelVotes
+		err := rows.Scan(&v.PositionName, &v.Name, &v.Position, &v.COEVotes, &v.CBAVotes, &v.CICSVotes, &v.CITVotes,
+			&v.COEDBSED, &v.COEDBEED, &v.COEDBPED, &v.COEDVotes, &v.TotalVotes)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, v)
+	}
+
+	return counts, nil
+}
+
+// BuildCanvassDocument assembles the final counts, ballot digest and winners from the database
+func BuildCanvassDocument() (models.CanvassDocument, error) {
+	var document models.CanvassDocument
+
+	err := DB.QueryRow(`SELECT voting_start, voting_end FROM election_settings LIMIT 1`).Scan(&document.VotingStart, &document.VotingEnd)
+	if err != nil {
+		return document, err
+	}
+
+	document.BallotsDigest, document.BallotsCast, err = ballotsDigest()
+	if err != nil {
+		return document, err
+	}
+
+	err = DB.QueryRow(`SELECT COUNT(*), COALESCE(SUM(has_voted), 0) FROM voters`).Scan(&document.Turnout.Registered, &document.Turnout.Voted)
+	if err != nil {
+		return document, err
+	}
+	document.Turnout.Percentage = Percentage(document.Turnout.Voted, document.Turnout.Registered)
+
+	document.Counts, err = canvassCounts()
+	if err != nil {
+		return document, err
+	}
+
+	outcomes, err := ComputeOutcomes()
+	if err != nil {
+		return document, err
+	}
+	for _, outcome := range outcomes {
+		if outcome.Status == OutcomeTied {
+			return document, fmt.Errorf("%w: the tie for %s has not been resolved", ErrCertificationRefused, outcome.Position)
+		}
+	}
+	document.Winners = FinalWinners(outcomes)
+
+	return document, nil
+}
+
+// CertifyElection freezes the results, signs the canvass document and stores the record
+func CertifyElection(certifiedBy string) (models.CanvassRecord, error) {
+	var record models.CanvassRecord
+
+	if ElectionOpen() {
+		return record, fmt.Errorf("%w: the election is still open", ErrCertificationRefused)
+	}
+	if ElectionCertified() {
+		return record, fmt.Errorf("%w: the election is already certified", ErrCertificationRefused)
+	}
+
+	key, err := certificationKey()
+	if err != nil {
+		return record, err
+	}
+
+	document, err := BuildCanvassDocument()
+	if err != nil {
+		return record, err
+	}
+	document.CertifiedBy = certifiedBy
+	document.CertifiedAt = time.Now().Format(time.RFC3339)
+
+	body, err := json.Marshal(document)
+	if err != nil {
+		return record, err
+	}
+	digest := sha256.Sum256(body)
+
+	record = models.CanvassRecord{
+		Document:  string(body),
+		Digest:    hex.EncodeToString(digest[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, body)),
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return record, err
+	}
+
+	// Locking the settings row makes a concurrent certification wait and then see certified_at
+	result, err := tx.Exec(`UPDATE election_settings SET certified_at = NOW(), is_active = FALSE WHERE certified_at IS NULL`)
+	if err != nil {
+		tx.Rollback()
+		return record, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		tx.Rollback()
+		return record, fmt.Errorf("%w: the election is already certified", ErrCertificationRefused)
+	}
+
+	result, err = tx.Exec(`INSERT INTO canvass_records (certified_at, certified_by, document, digest, signature, public_key) VALUES (NOW(), ?, ?, ?, ?, ?)`,
+		certifiedBy, record.Document, record.Digest, record.Signature, record.PublicKey)
+	if err != nil {
+		tx.Rollback()
+		return record, err
+	}
+	record.ID, _ = result.LastInsertId()
+
+	if err := tx.Commit(); err != nil {
+		return record, err
+	}
+
+	InvalidateResults()
+	return record, nil
+}
+
+// StartNewElection clears the current election so another can be run: its candidates, partylists,
+// positions, voters, ballots, counts and settings, which also clears its certification. Signed canvass
+// records are kept as the archive of past elections. It refuses while voting is under way.
+func StartNewElection(username string) error {
+	if ElectionOpen() {
+		return ErrElectionOpen
+	}
+
+	var certifiedAt sql.NullString
+	if err := DB.QueryRow(`SELECT certified_at FROM election_settings LIMIT 1`).Scan(&certifiedAt); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// DELETE rather than TRUNCATE, which commits implicitly and could not be rolled back
+	for _, table := range []string{"tie_breaks", "ballots", "votes", "candidates", "partylists", "positions", "voters", "election_settings"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	details := map[string]interface{}{"certified_at": nil}
+	if certifiedAt.Valid {
+		details["certified_at"] = certifiedAt.String
+	}
+	if err := RecordAudit(tx, username, "election.new", "", details); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	InvalidateResults()
+
+	// The candidates are gone, so none of the stored photos are used any more
+	photos, err := Photos.List()
+	if err != nil {
+		return fmt.Errorf("failed to list photos: %w", err)
+	}
+	for _, photo := range photos {
+		if err := Photos.Delete(photo.Key); err != nil {
+			revel.AppLog.Errorf("Failed to delete photo %s: %v", photo.Key, err)
+		}
+	}
+	return nil
+}
+
+// GetLatestCanvass returns the most recent signed canvass record of the current election. A record
+// left over from an earlier election is not returned once the election is no longer certified.
+func GetLatestCanvass() (models.CanvassRecord, error) {
+	var record models.CanvassRecord
+	err := DB.QueryRow(`SELECT id, document, digest, signature, public_key FROM canvass_records
+		WHERE EXISTS (SELECT 1 FROM election_settings WHERE certified_at IS NOT NULL) ORDER BY id DESC LIMIT 1`).
+		Scan(&record.ID, &record.Document, &record.Digest, &record.Signature, &record.PublicKey)
+	return record, err
+}
+
+// VerifyCanvass checks the record's digest, its signature against the configured public key, and whether
+// the stored ballots and counts still match it
+func VerifyCanvass(record models.CanvassRecord) (models.CanvassVerification, error) {
+	var verification models.CanvassVerification
+
+	digest := sha256.Sum256([]byte(record.Document))
+	verification.DigestValid = hex.EncodeToString(digest[:]) == record.Digest
+
+	publicKey, err := certificationPublicKey()
+	if err != nil {
+		revel.AppLog.Errorf("Cannot verify canvass signatures: %v", err)
+	} else {
+		verification.KeyMatches = record.PublicKey == base64.StdEncoding.EncodeToString(publicKey)
+		if signature, err := base64.StdEncoding.DecodeString(record.Signature); err == nil {
+			verification.SignatureValid = ed25519.Verify(publicKey, []byte(record.Document), signature)
+		}
+	}
+
+	var document models.CanvassDocument
+	if err := json.Unmarshal([]byte(record.Document), &document); err != nil {
+		return verification, err
+	}
+
+	currentDigest, currentCount, err := ballotsDigest()
+	if err != nil {
+		return verification, err
+	}
+	verification.BallotsMatch = currentDigest == document.BallotsDigest && currentCount == document.BallotsCast
+
+	counts, err := canvassCounts()
+	if err != nil {
+		return verification, err
+	}
+	certifiedCounts, _ := json.Marshal(document.Counts)
+	currentCounts, _ := json.Marshal(counts)
+	verification.CountsMatch = string(certifiedCounts) == string(currentCounts)
+
+	verification.Valid = verification.DigestValid && verification.SignatureValid && verification.KeyMatches &&
+		verification.BallotsMatch && verification.CountsMatch
+
+	return verification, nil
+}
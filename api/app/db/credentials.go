@@ -0,0 +1,88 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"api/app/models"
+)
+
+// Most credentials a single candidate may list
+const maxCredentials = 20
+
+// CredentialTypes are the kinds of credential a candidate may list
+var CredentialTypes = map[string]bool{
+	"award":        true,
+	"position":     true,
+	"organization": true,
+	"education":    true,
+	"achievement":  true,
+	"other":        true,
+}
+
+// NormalizeCredentials validates a candidate's credentials, defaults missing types to "other" and renumbers
+// them 1..n in display order. Entries without an order keep their submitted position after ordered ones.
+func NormalizeCredentials(credentials []models.Credential) ([]models.Credential, error) {
+	if len(credentials) > maxCredentials {
+		return nil, fmt.Errorf("at most %d credentials are allowed", maxCredentials)
+	}
+
+	normalized := make([]models.Credential, len(credentials))
+	for i, credential := range credentials {
+		credential.Type = strings.ToLower(strings.TrimSpace(credential.Type))
+		credential.Title = strings.TrimSpace(credential.Title)
+		credential.Description = strings.TrimSpace(credential.Description)
+		if credential.Type == "" {
+			credential.Type = "other"
+		}
+
+		switch {
+		case !CredentialTypes[credential.Type]:
+			return nil, fmt.Errorf("credential %d: unknown type %q", i+1, credential.Type)
+		case credential.Title == "":
+			return nil, fmt.Errorf("credential %d: title is required", i+1)
+		case len(credential.Title) > 200:
+			return nil, fmt.Errorf("credential %d: title must be at most 200 characters", i+1)
+		case len(credential.Description) > 1000:
+			return nil, fmt.Errorf("credential %d: description must be at most 1000 characters", i+1)
+		case credential.Year != 0 && (credential.Year < 1900 || credential.Year > time.Now().Year()+1):
+			return nil, fmt.Errorf("credential %d: year %d is out of range", i+1, credential.Year)
+		case credential.Order < 0:
+			return nil, fmt.Errorf("credential %d: order must not be negative", i+1)
+		}
+		normalized[i] = credential
+	}
+
+	SortCredentials(normalized)
+	for i := range normalized {
+		normalized[i].Order = i + 1
+	}
+	return normalized, nil
+}
+
+// SortCredentials puts credentials in display order; unordered (legacy) entries keep their stored sequence
+func SortCredentials(credentials []models.Credential) {
+	sort.SliceStable(credentials, func(i, j int) bool {
+		oi, oj := credentials[i].Order, credentials[j].Order
+		if oi == 0 || oj == 0 {
+			return oi != 0 && oj == 0
+		}
+		return oi < oj
+	})
+}
+
+// ParseCredentials reads a stored credentials column, which may hold structured entries or legacy strings
+func ParseCredentials(raw string) ([]models.Credential, error) {
+	credentials := []models.Credential{}
+	if raw == "" || raw == "null" {
+		return credentials, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &credentials); err != nil {
+		return nil, err
+	}
+	SortCredentials(credentials)
+	return credentials, nil
+}
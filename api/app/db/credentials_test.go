@@ -0,0 +1,160 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"api/app/models"
+)
+
+func TestNormalizeCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []models.Credential
+		want    []models.Credential
+		wantErr string
+	}{
+		{
+			name:  "none",
+			input: []models.Credential{},
+			want:  []models.Credential{},
+		},
+		{
+			name: "trims, lowercases and defaults the type",
+			input: []models.Credential{
+				{Type: " Award ", Title: "  Dean's Lister ", Description: " 2 semesters ", Year: 2024},
+				{Title: "Debate club"},
+			},
+			want: []models.Credential{
+				{Type: "award", Title: "Dean's Lister", Description: "2 semesters", Year: 2024, Order: 1},
+				{Type: "other", Title: "Debate club", Order: 2},
+			},
+		},
+		{
+			name: "ordered entries first, then unordered ones in submitted sequence",
+			input: []models.Credential{
+				{Title: "C"},
+				{Title: "B", Order: 7},
+				{Title: "D"},
+				{Title: "A", Order: 3},
+			},
+			want: []models.Credential{
+				{Type: "other", Title: "A", Order: 1},
+				{Type: "other", Title: "B", Order: 2},
+				{Type: "other", Title: "C", Order: 3},
+				{Type: "other", Title: "D", Order: 4},
+			},
+		},
+		{
+			name: "equal orders keep their submitted sequence",
+			input: []models.Credential{
+				{Title: "first", Order: 1},
+				{Title: "second", Order: 1},
+			},
+			want: []models.Credential{
+				{Type: "other", Title: "first", Order: 1},
+				{Type: "other", Title: "second", Order: 2},
+			},
+		},
+		{
+			name:  "next year is allowed",
+			input: []models.Credential{{Type: "education", Title: "Graduating", Year: time.Now().Year() + 1}},
+			want:  []models.Credential{{Type: "education", Title: "Graduating", Year: time.Now().Year() + 1, Order: 1}},
+		},
+		{
+			name:    "unknown type",
+			input:   []models.Credential{{Title: "ok"}, {Type: "medal", Title: "Gold"}},
+			wantErr: `credential 2: unknown type "medal"`,
+		},
+		{
+			name:    "blank title",
+			input:   []models.Credential{{Type: "award", Title: "   "}},
+			wantErr: "credential 1: title is required",
+		},
+		{
+			name:    "long title",
+			input:   []models.Credential{{Title: strings.Repeat("t", 201)}},
+			wantErr: "credential 1: title must be at most 200 characters",
+		},
+		{
+			name:    "long description",
+			input:   []models.Credential{{Title: "ok", Description: strings.Repeat("d", 1001)}},
+			wantErr: "credential 1: description must be at most 1000 characters",
+		},
+		{
+			name:    "year too early",
+			input:   []models.Credential{{Title: "ok", Year: 1899}},
+			wantErr: "credential 1: year 1899 is out of range",
+		},
+		{
+			name:    "year too late",
+			input:   []models.Credential{{Title: "ok", Year: time.Now().Year() + 2}},
+			wantErr: "out of range",
+		},
+		{
+			name:    "negative order",
+			input:   []models.Credential{{Title: "ok", Order: -1}},
+			wantErr: "credential 1: order must not be negative",
+		},
+		{
+			name:    "too many",
+			input:   make([]models.Credential, maxCredentials+1),
+			wantErr: "at most 20 credentials are allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeCredentials(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error is %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeCredentials: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []models.Credential
+	}{
+		{"empty", "", []models.Credential{}},
+		{"null", "null", []models.Credential{}},
+		{"legacy strings", `["Leader of Babymonster", "Rapper"]`, []models.Credential{
+			{Type: "other", Title: "Leader of Babymonster"},
+			{Type: "other", Title: "Rapper"},
+		}},
+		{"structured, sorted by order", `[{"type":"award","title":"B","order":2},{"type":"position","title":"A","order":1}]`, []models.Credential{
+			{Type: "position", Title: "A", Order: 1},
+			{Type: "award", Title: "B", Order: 2},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCredentials(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseCredentials: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseCredentials("{not json"); err == nil {
+		t.Error("ParseCredentials accepted invalid JSON")
+	}
+}
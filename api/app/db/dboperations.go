@@ -65,7 +65,7 @@ func IdentifyDepartment(dept string) (string, error) {
 	case "bachelor of physical education",
 		"bachelor of technical vocational teacher education",
 		"bachelor of technology and livelihood education major in home economics":
-		return "coed_bped_btled_btvded", nil
+		return "coed_bped", nil
 	default:
 		return "", sql.ErrNoRows
 	}
@@ -131,9 +131,10 @@ func ComparePasswords(storedHash, password string) bool {
 
 var jwtSecret = GenerateSecretKey()
 
-func GenerateJWT(username string) (string, error) {
+func GenerateJWT(username, role string) (string, error) {
 	claims := jwt.MapClaims{
 		"username": username,
+		"role":     role,
 		"exp":      time.Now().Add(time.Hour * 1).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -163,101 +164,213 @@ func ValidateJWT(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
-// Fetch Voters
-func GetVotersData() [][]string {
-	rows, err := DB.Query("SELECT student_id, student_name, program, has_voted FROM voters")
+// AdminFromJWT returns the username and role carried by a valid admin token.
+// Tokens issued before roles existed are treated as admins.
+func AdminFromJWT(tokenString string) (string, string, error) {
+	token, err := ValidateJWT(tokenString)
+	if err != nil || token == nil {
+		return "", "", fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", fmt.Errorf("invalid token claims")
+	}
+
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+	if role == "" {
+		role = "admin"
+	}
+	return username, role, nil
+}
+
+// StreamVoters calls fn with each registered voter as it is read, so a large roll is never held in
+// memory. It stops at the first error fn returns.
+func StreamVoters(fn func(models.ExcelVoters) error) error {
+	rows, err := DB.Query("SELECT student_id, student_name, program, has_voted, fingerprint_hash FROM voters")
 	if err != nil {
-		revel.AppLog.Errorf("Failed to fetch voters: %v", err)
-		return nil
+		return err
 	}
 	defer rows.Close()
 
-	var voters []models.ExcelVoters
 	for rows.Next() {
 		var v models.ExcelVoters
-		err := rows.Scan(&v.StudentID, &v.StudentName, &v.Program, &v.HasVoted)
+		err := rows.Scan(&v.StudentID, &v.StudentName, &v.Program, &v.HasVoted, &v.FingerprintHash)
 		if err != nil {
 			revel.AppLog.Errorf("Failed to scan voter: %v", err)
 			continue
 		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetVoters lists every registered voter
+func GetVoters() ([]models.ExcelVoters, error) {
+	voters := []models.ExcelVoters{}
+	err := StreamVoters(func(v models.ExcelVoters) error {
 		voters = append(voters, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return voters, nil
+}
 
-	data := [][]string{
-		{"Student ID", "Name", "Program", "Has Voted"},
+// exportFingerprints reports whether exports may hold voters' fingerprint hashes. They are only
+// written to backups that are encrypted; a restore from a backup without them keeps the stored ones.
+func exportFingerprints() bool {
+	key, err := backupKey()
+	return err == nil && key != nil
+}
+
+// StreamVotersData calls emit with the Voters sheet's header and then one row per voter. The
+// "Fingerprint Hash" column is only written when backups are encrypted.
+func StreamVotersData(emit func([]string) error) error {
+	return streamVotersData(exportFingerprints(), emit)
+}
+
+func streamVotersData(withFingerprints bool, emit func([]string) error) error {
+	header := []string{"Student ID", "Name", "Program", "Has Voted"}
+	if withFingerprints {
+		header = append(header, "Fingerprint Hash")
+	}
+	if err := emit(header); err != nil {
+		return err
 	}
-	for _, v := range voters {
+	return StreamVoters(func(v models.ExcelVoters) error {
 		hasVotedStr := "0"
 		if v.HasVoted {
 			hasVotedStr = "1"
 		}
-		data = append(data, []string{
+		row := []string{
 			SanitizeCellValue(v.StudentID),
 			SanitizeCellValue(v.StudentName),
 			SanitizeCellValue(v.Program),
 			hasVotedStr,
-		})
-	}
-
-	return data
+		}
+		if withFingerprints {
+			row = append(row, SanitizeCellValue(v.FingerprintHash))
+		}
+		return emit(row)
+	})
 }
 
-// Fetch Candidates
-func GetCandidatesData() [][]string {
-	rows, err := DB.Query(`SELECT position_name, name, position, year_level, program, partylist, credentials, photo_url FROM candidates`)
+// GetVotersData reads the Voters sheet with fingerprint hashes, for comparing the database with a
+// backup
+func GetVotersData() [][]string {
+	data, err := collectRows(func(emit func([]string) error) error {
+		return streamVotersData(true, emit)
+	})
 	if err != nil {
-		revel.AppLog.Error("Error fetching candidates: ", "error", err)
+		revel.AppLog.Errorf("Failed to fetch voters: %v", err)
 		return nil
 	}
-	defer rows.Close()
+	return data
+}
 
-	data := [][]string{
-		{"Position Name", "Name", "Position", "Year Level", "Program", "Partylist", "Credentials", "Photo URL"},
+// StreamCandidates calls fn with each candidate, withdrawn ones included, with their structured
+// credentials. It stops at the first error fn returns.
+func StreamCandidates(fn func(models.Candidate) error) error {
+	rows, err := DB.Query(`SELECT id, position_name, name, position, COALESCE(year_level, ''), COALESCE(program, ''), partylist, partylist_id,
+		credentials, COALESCE(photo_url, ''), COALESCE(thumbnail_url, ''), COALESCE(DATE_FORMAT(withdrawn_at, '%Y-%m-%d %H:%i:%s'), '') FROM candidates ORDER BY id`)
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var positionName, name, position, yearLevel, program, partylist, credentialsJSON, photoURL string
-		if err := rows.Scan(&positionName, &name, &position, &yearLevel, &program, &partylist, &credentialsJSON, &photoURL); err != nil {
+		var candidate models.Candidate
+		var partylistID sql.NullInt64
+		var credentialsJSON sql.NullString
+		err := rows.Scan(&candidate.ID, &candidate.PositionName, &candidate.Name, &candidate.Position, &candidate.YearLevel, &candidate.Program,
+			&candidate.Partylist, &partylistID, &credentialsJSON, &candidate.PhotoURL, &candidate.ThumbnailURL, &candidate.WithdrawnAt)
+		if err != nil {
 			revel.AppLog.Errorf("Failed to scan candidate row: %v", err)
 			continue
 		}
+		candidate.Withdrawn = candidate.WithdrawnAt != ""
 
-		// Parse credentials JSON into []string
-		var credentials []string
-		if err := json.Unmarshal([]byte(credentialsJSON), &credentials); err != nil {
-			revel.AppLog.Warnf("Failed to parse credentials for %s: %v", name, err)
-			// Handle gracefully (use raw JSON if parsing fails)
-			credentials = []string{credentialsJSON}
+		if partylistID.Valid {
+			candidate.PartylistID = &partylistID.Int64
 		}
+		if candidate.Credentials, err = ParseCredentials(credentialsJSON.String); err != nil {
+			revel.AppLog.Warnf("Failed to parse credentials for %s: %v", candidate.Name, err)
+			candidate.Credentials = []models.Credential{}
+		}
+		if err := fn(candidate); err != nil {
+			return err
+		}
+	}
 
-		// Convert []string to single comma-separated string for Excel
-		credentialsString := strings.Join(credentials, " | ")
+	return rows.Err()
+}
 
-		data = append(data, []string{
-			SanitizeCellValue(positionName),
-			SanitizeCellValue(name),
-			SanitizeCellValue(position),
-			SanitizeCellValue(yearLevel),
-			SanitizeCellValue(program),
-			SanitizeCellValue(partylist),
-			SanitizeCellValue(credentialsString),
-			SanitizeCellValue(photoURL),
-		})
+// GetCandidates lists every candidate, withdrawn ones included, with their structured credentials
+func GetCandidates() ([]models.Candidate, error) {
+	candidates := []models.Candidate{}
+	err := StreamCandidates(func(candidate models.Candidate) error {
+		candidates = append(candidates, candidate)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return candidates, nil
+}
 
+// StreamCandidatesData calls emit with the Candidates sheet's header and then one row per candidate
+func StreamCandidatesData(emit func([]string) error) error {
+	if err := emit([]string{"ID", "Position Name", "Name", "Position", "Year Level", "Program", "Partylist", "Credentials", "Photo URL",
+		"Thumbnail URL", "Withdrawn At"}); err != nil {
+		return err
+	}
+	return StreamCandidates(func(candidate models.Candidate) error {
+		// Credentials are kept as structured JSON so a restore loses nothing
+		credentialsJSON, err := json.Marshal(candidate.Credentials)
+		if err != nil {
+			revel.AppLog.Warnf("Failed to encode credentials for %s: %v", candidate.Name, err)
+		}
+
+		return emit([]string{
+			fmt.Sprintf("%d", candidate.ID),
+			SanitizeCellValue(candidate.PositionName),
+			SanitizeCellValue(candidate.Name),
+			SanitizeCellValue(candidate.Position),
+			SanitizeCellValue(candidate.YearLevel),
+			SanitizeCellValue(candidate.Program),
+			SanitizeCellValue(candidate.Partylist),
+			SanitizeCellValue(string(credentialsJSON)),
+			SanitizeCellValue(candidate.PhotoURL),
+			SanitizeCellValue(candidate.ThumbnailURL),
+			candidate.WithdrawnAt,
+		})
+	})
+}
+
+// Fetch Candidates
+func GetCandidatesData() [][]string {
+	data, err := collectRows(StreamCandidatesData)
+	if err != nil {
+		revel.AppLog.Error("Error fetching candidates: ", "error", err)
+		return nil
+	}
 	return data
 }
 
-// Fetch Votes
-func GetVotesData() [][]string {
+// StreamVotes calls fn with each candidate's vote counters. It stops at the first error fn returns.
+func StreamVotes(fn func(models.ExcelVotes) error) error {
 	rows, err := DB.Query("SELECT position_name, name, position, coe_votes, cba_votes, cics_votes, cit_votes, coed_bsed, coed_beed, coed_bped, coed_votes, total_votes FROM votes")
 	if err != nil {
-		revel.AppLog.Errorf("Failed to fetch votes: %v", err)
-		return nil
+		return err
 	}
 	defer rows.Close()
 
-	var votes []models.ExcelVotes
 	for rows.Next() {
 		var v models.ExcelVotes
 		err := rows.Scan(&v.PositionName, &v.Name, &v.Position, &v.COEVotes, &v.CBAVotes, &v.CICSVotes, &v.CITVotes,
@@ -266,14 +379,35 @@ func GetVotesData() [][]string {
 			revel.AppLog.Errorf("Failed to scan vote: %v", err)
 			continue
 		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetVotes lists each candidate's vote counters
+func GetVotes() ([]models.ExcelVotes, error) {
+	votes := []models.ExcelVotes{}
+	err := StreamVotes(func(v models.ExcelVotes) error {
 		votes = append(votes, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return votes, nil
+}
 
-	data := [][]string{
-		{"Position Name", "Name", "Position", "COE Votes", "CBA Votes", "CICS Votes", "CIT Votes", "COED BSED Votes", "COED BEED Votes", "COED BPED Votes", "COED Total Votes", "Final Total Votes"},
+// StreamVotesData calls emit with the Votes sheet's header and then one row per candidate
+func StreamVotesData(emit func([]string) error) error {
+	err := emit([]string{"Position Name", "Name", "Position", "COE Votes", "CBA Votes", "CICS Votes", "CIT Votes", "COED BSED Votes", "COED BEED Votes", "COED BPED Votes", "COED Total Votes", "Final Total Votes"})
+	if err != nil {
+		return err
 	}
-	for _, v := range votes {
-		data = append(data, []string{
+	return StreamVotes(func(v models.ExcelVotes) error {
+		return emit([]string{
 			SanitizeCellValue(v.PositionName),
 			SanitizeCellValue(v.Name),
 			SanitizeCellValue(v.Position),
@@ -287,21 +421,129 @@ func GetVotesData() [][]string {
 			fmt.Sprintf("%d", v.COEDVotes),
 			fmt.Sprintf("%d", v.TotalVotes),
 		})
+	})
+}
+
+// Fetch Votes
+func GetVotesData() [][]string {
+	data, err := collectRows(StreamVotesData)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch votes: %v", err)
+		return nil
 	}
+	return data
+}
 
+// StreamBallotsData calls emit with the Ballots sheet's header and then one row per ballot, so a
+// restore can bring back the selections ranked-choice positions are counted from
+func StreamBallotsData(emit func([]string) error) error {
+	if err := emit([]string{"Ballot ID", "Kiosk ID", "Department", "Selections", "Cast At"}); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query(`SELECT ballot_id, kiosk_id, department, selections, DATE_FORMAT(cast_at, '%Y-%m-%d %H:%i:%s') FROM ballots ORDER BY cast_at, ballot_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ballotID, kioskID, department, selections, castAt string
+		if err := rows.Scan(&ballotID, &kioskID, &department, &selections, &castAt); err != nil {
+			revel.AppLog.Errorf("Failed to scan ballot: %v", err)
+			continue
+		}
+		err := emit([]string{
+			SanitizeCellValue(ballotID),
+			SanitizeCellValue(kioskID),
+			SanitizeCellValue(department),
+			SanitizeCellValue(selections),
+			castAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Fetch Ballots
+func GetBallotsData() [][]string {
+	data, err := collectRows(StreamBallotsData)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch ballots: %v", err)
+		return nil
+	}
 	return data
 }
 
-// Fetch Election Settings
-func GetElectionSettingsData() [][]string {
-	rows, err := DB.Query("SELECT voting_start, voting_end FROM election_settings")
+// StreamTieBreaksData calls emit with the TieBreaks sheet's header and then one row per resolved tie
+func StreamTieBreaksData(emit func([]string) error) error {
+	if err := emit([]string{"Position", "Winners", "Method", "Notes", "Resolved By", "Resolved At"}); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query(`SELECT position, winners, method, COALESCE(notes, ''), resolved_by, DATE_FORMAT(resolved_at, '%Y-%m-%d %H:%i:%s') FROM tie_breaks ORDER BY position`)
 	if err != nil {
-		revel.AppLog.Errorf("Failed to fetch election settings: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var position, winners, method, notes, resolvedBy, resolvedAt string
+		if err := rows.Scan(&position, &winners, &method, &notes, &resolvedBy, &resolvedAt); err != nil {
+			revel.AppLog.Errorf("Failed to scan tie break: %v", err)
+			continue
+		}
+		err := emit([]string{
+			SanitizeCellValue(position),
+			SanitizeCellValue(winners),
+			SanitizeCellValue(method),
+			SanitizeCellValue(notes),
+			SanitizeCellValue(resolvedBy),
+			resolvedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Fetch Tie Breaks
+func GetTieBreaksData() [][]string {
+	data, err := collectRows(StreamTieBreaksData)
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch tie breaks: %v", err)
+		return nil
+	}
+	return data
+}
+
+// collectRows gathers a streamed sheet into memory, for callers that need all of it at once
+func collectRows(stream func(emit func([]string) error) error) ([][]string, error) {
+	data := [][]string{}
+	err := stream(func(row []string) error {
+		data = append(data, row)
 		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetElectionSettings returns the voting timeframe; there is at most one
+func GetElectionSettings() ([]models.ExcelElectionSetting, error) {
+	rows, err := DB.Query("SELECT voting_start, voting_end FROM election_settings")
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
-	var settings []models.ExcelElectionSetting
+	settings := []models.ExcelElectionSetting{}
 	for rows.Next() {
 		var s models.ExcelElectionSetting
 		err := rows.Scan(&s.VotingStart, &s.VotingEnd)
@@ -312,6 +554,17 @@ func GetElectionSettingsData() [][]string {
 		settings = append(settings, s)
 	}
 
+	return settings, nil
+}
+
+// Fetch Election Settings
+func GetElectionSettingsData() [][]string {
+	settings, err := GetElectionSettings()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch election settings: %v", err)
+		return nil
+	}
+
 	data := [][]string{
 		{"Voting Start", "Voting End"},
 	}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/revel/revel"
+)
+
+const (
+	EmbargoOff     = "off"
+	EmbargoTurnout = "turnout"
+	EmbargoHidden  = "hidden"
+)
+
+// EmbargoMode is the configured results policy while the election is open
+func EmbargoMode() string {
+	mode := EmbargoOff
+	if revel.Config != nil {
+		mode = revel.Config.StringDefault("results.embargo", mode)
+	}
+
+	switch mode {
+	case EmbargoTurnout, EmbargoHidden:
+		return mode
+	default:
+		return EmbargoOff
+	}
+}
+
+// ElectionOpen reports whether voting is active or the current time is inside the voting timeframe
+func ElectionOpen() bool {
+	var isOpen bool
+	err := DB.QueryRow(`SELECT is_active OR (NOW() BETWEEN voting_start AND voting_end) FROM election_settings LIMIT 1`).Scan(&isOpen)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			revel.AppLog.Errorf("Failed to fetch election settings: %v", err)
+		}
+		return false
+	}
+	return isOpen
+}
+
+// ActiveEmbargo returns the embargo mode in force for the given admin role ("" for the public),
+// or EmbargoOff when live counts may be shown
+func ActiveEmbargo(role string) string {
+	mode := EmbargoMode()
+	if mode == EmbargoOff || RoleSeesLiveResults(role) || !ElectionOpen() || ElectionCertified() {
+		return EmbargoOff
+	}
+	return mode
+}
+
+// RoleSeesLiveResults reports whether an admin role is exempt from the results embargo
+func RoleSeesLiveResults(role string) bool {
+	if role == "" {
+		return false
+	}
+
+	roles := "admin"
+	if revel.Config != nil {
+		roles = revel.Config.StringDefault("results.embargo.roles", roles)
+	}
+	for _, allowed := range strings.Split(roles, ",") {
+		if strings.TrimSpace(allowed) == role {
+			return true
+		}
+	}
+	return false
+}
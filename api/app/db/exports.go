@@ -0,0 +1,438 @@
+package db
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"api/app/models"
+)
+
+// Formats an election can be exported in
+const (
+	FormatXLSX = "xlsx"
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+	FormatSQL  = "sql"
+)
+
+// ErrUnknownFormat is returned for an export format other than xlsx, csv, json or sql
+var ErrUnknownFormat = errors.New("unknown export format")
+
+type exportFormat struct {
+	Extension   string
+	ContentType string
+	// Sections is how many sheets or tables Render reports progress for
+	Sections int
+	// Render writes the export to w and returns the number of records written per sheet or table
+	Render func(w io.Writer, progress *exportProgress) (map[string]int, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	FormatXLSX: {Extension: ".xlsx", ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Sections: len(exportSheets), Render: renderXLSX},
+	FormatCSV:  {Extension: ".zip", ContentType: "application/zip", Sections: len(exportSheets), Render: renderCSV},
+	FormatJSON: {Extension: ".json", ContentType: "application/json", Sections: len(jsonSections), Render: renderJSON},
+	FormatSQL:  {Extension: ".sql", ContentType: "application/sql", Sections: len(sqlTables), Render: renderSQL},
+}
+
+// exportSheets are the sheets written to workbooks and CSV archives, in order. Stream calls emit with
+// the header and then each row; the large tables are streamed straight from the database.
+var exportSheets = []struct {
+	Name   string
+	Stream func(emit func([]string) error) error
+}{
+	{"ElectionSettings", fetchedRows(GetElectionSettingsData)},
+	{"Partylists", fetchedRows(GetPartylistsData)},
+	{"Candidates", StreamCandidatesData},
+	{"Votes", StreamVotesData},
+	{"Voters", StreamVotersData},
+	{"Ballots", StreamBallotsData},
+	{"TieBreaks", StreamTieBreaksData},
+	{"Turnout", fetchedRows(GetTurnoutData)},
+	{"RankedChoice", fetchedRows(GetRankedChoiceData)},
+}
+
+// fetchedRows streams a sheet that is small enough to be fetched whole
+func fetchedRows(fetch func() [][]string) func(emit func([]string) error) error {
+	return func(emit func([]string) error) error {
+		data := fetch()
+		if data == nil {
+			return errors.New("table could not be read")
+		}
+		for _, row := range data {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// renderCSV writes each export sheet as a CSV file in a zip archive
+func renderCSV(w io.Writer, progress *exportProgress) (map[string]int, error) {
+	archive := zip.NewWriter(w)
+	rowCounts := map[string]int{}
+
+	for _, sheet := range exportSheets {
+		progress.startSection(sheet.Name)
+
+		entry, err := archive.Create(sheet.Name + ".csv")
+		if err != nil {
+			return nil, err
+		}
+
+		writer := csv.NewWriter(entry)
+		rows := 0
+		err = sheet.Stream(func(row []string) error {
+			rows++
+			progress.addRows(1)
+			return writer.Write(row)
+		})
+		if err == nil {
+			writer.Flush()
+			err = writer.Error()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s.csv: %w", sheet.Name, err)
+		}
+
+		rowCounts[sheet.Name] = max(rows-1, 0)
+		progress.finishSection()
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return rowCounts, nil
+}
+
+// jsonSections are the parts of the JSON document, fetched in order
+var jsonSections = []struct {
+	Name  string
+	Fetch func(export *models.ElectionExport) (int, error)
+}{
+	{"election_settings", func(export *models.ElectionExport) (n int, err error) {
+		export.ElectionSettings, err = GetElectionSettings()
+		return len(export.ElectionSettings), err
+	}},
+	{"partylists", func(export *models.ElectionExport) (n int, err error) {
+		export.Partylists, err = GetPartylists()
+		return len(export.Partylists), err
+	}},
+	{"candidates", func(export *models.ElectionExport) (n int, err error) {
+		export.Candidates, err = GetCandidates()
+		return len(export.Candidates), err
+	}},
+	{"votes", func(export *models.ElectionExport) (n int, err error) {
+		export.Votes, err = GetVotes()
+		return len(export.Votes), err
+	}},
+	{"voters", func(export *models.ElectionExport) (n int, err error) {
+		export.Voters, err = GetVoters()
+		if !exportFingerprints() {
+			for i := range export.Voters {
+				export.Voters[i].FingerprintHash = ""
+			}
+		}
+		return len(export.Voters), err
+	}},
+	{"turnout", func(export *models.ElectionExport) (n int, err error) {
+		export.Turnout, err = GetTurnout()
+		return -1, err
+	}},
+	{"ranked_choice", func(export *models.ElectionExport) (n int, err error) {
+		export.RankedChoice, err = GetRankedChoiceResults()
+		if export.RankedChoice == nil {
+			export.RankedChoice = []models.RankedChoiceResult{}
+		}
+		return len(export.RankedChoice), err
+	}},
+}
+
+// renderJSON writes the election as one document made of the API models. The document is built from
+// whole tables, so unlike the other formats it holds every table in memory.
+func renderJSON(w io.Writer, progress *exportProgress) (map[string]int, error) {
+	export := models.ElectionExport{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		AppVersion: appVersion(),
+	}
+
+	// Turnout is a summary rather than a list of records, so it has no row count
+	rowCounts := map[string]int{}
+	for _, section := range jsonSections {
+		progress.startSection(section.Name)
+		n, err := section.Fetch(&export)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", section.Name, err)
+		}
+		if n >= 0 {
+			rowCounts[section.Name] = n
+			progress.addRows(n)
+		}
+		progress.finishSection()
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return nil, err
+	}
+	return rowCounts, nil
+}
+
+// sqlTables are the tables written to SQL dumps, in order, as standard SQL column definitions. Stream
+// calls emit with each row's values in column order.
+var sqlTables = []struct {
+	Name    string
+	Columns []string
+	Stream  func(emit func([]interface{}) error) error
+}{
+	{
+		Name:    "election_settings",
+		Columns: []string{"voting_start TIMESTAMP NOT NULL", "voting_end TIMESTAMP NOT NULL"},
+		Stream: func(emit func([]interface{}) error) error {
+			settings, err := GetElectionSettings()
+			if err != nil {
+				return err
+			}
+			for _, s := range settings {
+				if err := emit([]interface{}{s.VotingStart, s.VotingEnd}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "partylists",
+		Columns: []string{"id INTEGER PRIMARY KEY", "name VARCHAR(50) NOT NULL", "acronym VARCHAR(20)", "logo_url VARCHAR(255)", "platform TEXT", "color CHAR(7)"},
+		Stream: func(emit func([]interface{}) error) error {
+			partylists, err := GetPartylists()
+			if err != nil {
+				return err
+			}
+			for _, p := range partylists {
+				if err := emit([]interface{}{p.ID, p.Name, p.Acronym, p.LogoURL, p.Platform, p.Color}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name: "candidates",
+		Columns: []string{"id INTEGER PRIMARY KEY", "position_name VARCHAR(355) NOT NULL", "name VARCHAR(255) NOT NULL", "position VARCHAR(100) NOT NULL",
+			"year_level VARCHAR(20)", "program VARCHAR(100)", "partylist VARCHAR(50) NOT NULL", "partylist_id INTEGER", "credentials TEXT",
+			"photo_url VARCHAR(255)", "thumbnail_url VARCHAR(255)", "withdrawn_at TIMESTAMP"},
+		Stream: func(emit func([]interface{}) error) error {
+			return StreamCandidates(func(c models.Candidate) error {
+				credentialsJSON, err := json.Marshal(c.Credentials)
+				if err != nil {
+					return err
+				}
+				var withdrawnAt interface{}
+				if c.Withdrawn {
+					withdrawnAt = c.WithdrawnAt
+				}
+				return emit([]interface{}{c.ID, c.PositionName, c.Name, c.Position, c.YearLevel, c.Program,
+					c.Partylist, c.PartylistID, string(credentialsJSON), c.PhotoURL, c.ThumbnailURL, withdrawnAt})
+			})
+		},
+	},
+	{
+		Name: "votes",
+		Columns: []string{"position_name VARCHAR(355) PRIMARY KEY", "name VARCHAR(255)", "position VARCHAR(255) NOT NULL",
+			"coe_votes INTEGER NOT NULL", "cba_votes INTEGER NOT NULL", "cics_votes INTEGER NOT NULL", "cit_votes INTEGER NOT NULL",
+			"coed_bsed INTEGER NOT NULL", "coed_beed INTEGER NOT NULL", "coed_bped INTEGER NOT NULL", "coed_votes INTEGER NOT NULL", "total_votes INTEGER NOT NULL"},
+		Stream: func(emit func([]interface{}) error) error {
+			return StreamVotes(func(v models.ExcelVotes) error {
+				return emit([]interface{}{v.PositionName, v.Name, v.Position, v.COEVotes, v.CBAVotes, v.CICSVotes, v.CITVotes,
+					v.COEDBSED, v.COEDBEED, v.COEDBPED, v.COEDVotes, v.TotalVotes})
+			})
+		},
+	},
+	{
+		Name: "voters",
+		Columns: []string{"student_id VARCHAR(50) PRIMARY KEY", "student_name VARCHAR(255) NOT NULL", "program VARCHAR(100) NOT NULL",
+			"has_voted SMALLINT NOT NULL", "fingerprint_hash VARCHAR(64)"},
+		Stream: func(emit func([]interface{}) error) error {
+			// Fingerprint hashes are NULL unless backups are encrypted
+			withFingerprints := exportFingerprints()
+			return StreamVoters(func(v models.ExcelVoters) error {
+				var fingerprintHash interface{}
+				if withFingerprints {
+					fingerprintHash = v.FingerprintHash
+				}
+				return emit([]interface{}{v.StudentID, v.StudentName, v.Program, v.HasVoted, fingerprintHash})
+			})
+		},
+	},
+}
+
+// renderSQL writes the election tables as CREATE TABLE and INSERT statements using standard SQL types,
+// one INSERT per row. Derived data (turnout, ranked-choice rounds) is left out.
+func renderSQL(w io.Writer, progress *exportProgress) (map[string]int, error) {
+	buffer := bufio.NewWriter(w)
+	fmt.Fprintf(buffer, "-- Election data exported %s by api %s\n", time.Now().Format(time.RFC3339), appVersion())
+	buffer.WriteString("-- Standard SQL for loading into an empty database. Strings escape quotes by doubling them, so on\n")
+	buffer.WriteString("-- MySQL load it with: SET SESSION sql_mode = CONCAT(@@sql_mode, ',NO_BACKSLASH_ESCAPES');\n\n")
+	buffer.WriteString("BEGIN;\n")
+
+	rowCounts := map[string]int{}
+	for _, table := range sqlTables {
+		progress.startSection(table.Name)
+
+		names := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			names[i] = strings.Fields(column)[0]
+		}
+		insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (", table.Name, strings.Join(names, ", "))
+
+		fmt.Fprintf(buffer, "\nCREATE TABLE %s (\n    %s\n);\n\n", table.Name, strings.Join(table.Columns, ",\n    "))
+		rows := 0
+		err := table.Stream(func(row []interface{}) error {
+			values := make([]string, len(row))
+			for i, value := range row {
+				values[i] = sqlLiteral(value)
+			}
+			rows++
+			progress.addRows(1)
+			_, err := buffer.WriteString(insert + strings.Join(values, ", ") + ");\n")
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", table.Name, err)
+		}
+
+		rowCounts[table.Name] = rows
+		progress.finishSection()
+	}
+
+	buffer.WriteString("\nCOMMIT;\n")
+	if err := buffer.Flush(); err != nil {
+		return nil, err
+	}
+	return rowCounts, nil
+}
+
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case *int64:
+		if v == nil {
+			return "NULL"
+		}
+		return fmt.Sprintf("%d", *v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// DetectExportFormat tells which format an unencrypted export is in from its content, or returns ""
+// when it is none of them. Workbooks and CSV archives are both zip files; only workbooks hold
+// [Content_Types].xml.
+func DetectExportFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return FormatJSON
+	case bytes.HasPrefix(trimmed, []byte("--")), bytes.HasPrefix(trimmed, []byte("BEGIN;")):
+		return FormatSQL
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+	for _, file := range archive.File {
+		if file.Name == "[Content_Types].xml" {
+			return FormatXLSX
+		}
+	}
+	return FormatCSV
+}
+
+// exportRowCounts counts the records in an export the way its Render function did, so verification
+// can compare them with the manifest
+func exportRowCounts(format string, data []byte) (map[string]int, error) {
+	counts := map[string]int{}
+
+	switch format {
+	case FormatXLSX, "":
+		sheets, err := ReadBackupSheets(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New("file is not a readable Excel workbook")
+		}
+		for name, rows := range sheets {
+			counts[name] = max(len(rows)-1, 0)
+			if len(rows) > 0 && len(rows[0]) > 0 && rows[0][0] == noDataAvailable {
+				counts[name] = 0
+			}
+		}
+
+	case FormatCSV:
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, errors.New("file is not a readable zip archive")
+		}
+		for _, file := range archive.File {
+			entry, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("%s cannot be read: %w", file.Name, err)
+			}
+			records, err := csv.NewReader(entry).ReadAll()
+			entry.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s is not valid CSV: %w", file.Name, err)
+			}
+			counts[strings.TrimSuffix(file.Name, ".csv")] = max(len(records)-1, 0)
+		}
+
+	case FormatJSON:
+		var document map[string]json.RawMessage
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, errors.New("file is not a valid JSON document")
+		}
+		for key, raw := range document {
+			var records []json.RawMessage
+			if json.Unmarshal(raw, &records) == nil {
+				counts[key] = len(records)
+			}
+		}
+
+	case FormatSQL:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+		for scanner.Scan() {
+			// Every table is created before its rows are inserted, so empty tables count as 0
+			if table, ok := strings.CutPrefix(scanner.Text(), "CREATE TABLE "); ok {
+				counts[strings.Fields(table)[0]] = 0
+			} else if table, ok := strings.CutPrefix(scanner.Text(), "INSERT INTO "); ok {
+				counts[strings.Fields(table)[0]]++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// Signed kiosk requests are only accepted within this window of the kiosk's clock
+const kioskSignatureSkew = 5 * time.Minute
+
+// GenerateKioskCredentials creates a new kiosk ID and device secret
+func GenerateKioskCredentials() (string, string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+
+	return "kiosk-" + hex.EncodeToString(idBytes), base64.StdEncoding.EncodeToString(secretBytes), nil
+}
+
+// KioskSignature computes the HMAC-SHA256 signature a kiosk sends with each request. The signed
+// message is the method, path, unix timestamp, nonce and SHA-256 of the body separated by newlines.
+func KioskSignature(secret, method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	message := strings.Join([]string{strings.ToUpper(method), path, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Nonces of accepted kiosk requests, kept until their timestamp falls outside the signature window so
+// a captured request cannot be replayed
+var kioskNonces = struct {
+	sync.Mutex
+	seen map[string]time.Time
+}{seen: map[string]time.Time{}}
+
+// useKioskNonce records a request's nonce and reports false when the kiosk has already used it
+func useKioskNonce(kioskID, nonce string, now time.Time) bool {
+	kioskNonces.Lock()
+	defer kioskNonces.Unlock()
+
+	for key, seenAt := range kioskNonces.seen {
+		if now.Sub(seenAt) > 2*kioskSignatureSkew {
+			delete(kioskNonces.seen, key)
+		}
+	}
+
+	key := kioskID + "\n" + nonce
+	if _, ok := kioskNonces.seen[key]; ok {
+		return false
+	}
+	kioskNonces.seen[key] = now
+	return true
+}
+
+// AuthenticateKiosk verifies a signed request from an enrolled, non-revoked kiosk. Each request must
+// carry a fresh nonce of 16 to 64 characters.
+func AuthenticateKiosk(kioskID, timestamp, nonce, signature, method, path string, body []byte) bool {
+	if kioskID == "" || timestamp == "" || signature == "" || len(nonce) < 16 || len(nonce) > 64 {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > kioskSignatureSkew || skew < -kioskSignatureSkew {
+		return false
+	}
+
+	var secret string
+	var isRevoked bool
+	err = DB.QueryRow(`SELECT secret, is_revoked FROM kiosks WHERE kiosk_id = ?`, kioskID).Scan(&secret, &isRevoked)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			revel.AppLog.Errorf("Failed to fetch kiosk %s: %v", kioskID, err)
+		}
+		return false
+	}
+	if isRevoked {
+		return false
+	}
+
+	expected := KioskSignature(secret, method, path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return false
+	}
+	return useKioskNonce(kioskID, nonce, time.Now())
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	kioskRateMu      sync.Mutex
+	kioskRateWindows = make(map[string]*rateWindow)
+)
+
+// AllowKioskRequest applies a fixed one-minute window limit per kiosk
+func AllowKioskRequest(kioskID string) bool {
+	limit := 30
+	if revel.Config != nil {
+		limit = revel.Config.IntDefault("kiosk.lookup.rate", limit)
+	}
+
+	kioskRateMu.Lock()
+	defer kioskRateMu.Unlock()
+
+	now := time.Now()
+	window, ok := kioskRateWindows[kioskID]
+	if !ok || now.Sub(window.start) >= time.Minute {
+		kioskRateWindows[kioskID] = &rateWindow{start: now, count: 1}
+		return true
+	}
+
+	if window.count >= limit {
+		return false
+	}
+	window.count++
+	return true
+}
+
+// MaskName keeps the first letter of each word, e.g. "Seol Yoona" becomes "S*** Y****"
+func MaskName(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = string(runes[0]) + strings.Repeat("*", len(runes)-1)
+	}
+	return strings.Join(words, " ")
+}
+
+// RecordKioskBallot counts a ballot submitted through the given kiosk
+func RecordKioskBallot(tx *sql.Tx, kioskID string) error {
+	_, err := tx.Exec(`UPDATE kiosks SET ballots_cast = ballots_cast + 1, last_ballot_at = NOW() WHERE kiosk_id = ?`, kioskID)
+	return err
+}
+
+// KioskSilentThreshold is how long a kiosk may go without a heartbeat before it is reported as silent
+func KioskSilentThreshold() time.Duration {
+	threshold := 2 * time.Minute
+	if revel.Config != nil {
+		if value, ok := revel.Config.String("kiosk.heartbeat.silent"); ok {
+			if parsed, err := time.ParseDuration(value); err == nil {
+				threshold = parsed
+			}
+		}
+	}
+	return threshold
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKiosks backs a database/sql driver that answers AuthenticateKiosk's kiosk lookup, so it can be
+// tested without MySQL
+var testKiosks = map[string]struct {
+	secret  string
+	revoked bool
+}{
+	"kiosk-1":       {secret: "secret-1"},
+	"kiosk-revoked": {secret: "secret-revoked", revoked: true},
+}
+
+type kioskDriver struct{}
+
+func (kioskDriver) Open(string) (driver.Conn, error) { return kioskConn{}, nil }
+
+type kioskConn struct{}
+
+func (kioskConn) Prepare(query string) (driver.Stmt, error) {
+	if !strings.Contains(query, "FROM kiosks WHERE kiosk_id = ?") {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	return kioskStmt{}, nil
+}
+func (kioskConn) Close() error              { return nil }
+func (kioskConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions are not supported") }
+
+type kioskStmt struct{}
+
+func (kioskStmt) Close() error  { return nil }
+func (kioskStmt) NumInput() int { return 1 }
+func (kioskStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+func (kioskStmt) Query(args []driver.Value) (driver.Rows, error) {
+	kiosk, ok := testKiosks[args[0].(string)]
+	if !ok {
+		return &kioskRows{}, nil
+	}
+	return &kioskRows{values: [][]driver.Value{{kiosk.secret, kiosk.revoked}}}, nil
+}
+
+type kioskRows struct {
+	values [][]driver.Value
+}
+
+func (r *kioskRows) Columns() []string { return []string{"secret", "is_revoked"} }
+func (r *kioskRows) Close() error      { return nil }
+func (r *kioskRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("kiosktest", kioskDriver{})
+}
+
+func useKioskTestDB(t *testing.T) {
+	db, err := sql.Open("kiosktest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		db.Close()
+	})
+}
+
+func TestKioskSignature(t *testing.T) {
+	body := []byte(`{"student_id":"2021102614"}`)
+	base := KioskSignature("secret-1", "POST", "/api/post-vote", "1700000000", "0123456789abcdef", body)
+
+	if len(base) != 64 || strings.ToLower(base) != base {
+		t.Fatalf("signature %q is not lowercase hex SHA-256", base)
+	}
+	if again := KioskSignature("secret-1", "post", "/api/post-vote", "1700000000", "0123456789abcdef", body); again != base {
+		t.Errorf("method case changed the signature")
+	}
+
+	// Every signed field must change the signature
+	tests := []struct {
+		name                                   string
+		secret, method, path, timestamp, nonce string
+		body                                   []byte
+	}{
+		{"secret", "secret-2", "POST", "/api/post-vote", "1700000000", "0123456789abcdef", body},
+		{"method", "secret-1", "PUT", "/api/post-vote", "1700000000", "0123456789abcdef", body},
+		{"path", "secret-1", "POST", "/api/qr-api", "1700000000", "0123456789abcdef", body},
+		{"timestamp", "secret-1", "POST", "/api/post-vote", "1700000001", "0123456789abcdef", body},
+		{"nonce", "secret-1", "POST", "/api/post-vote", "1700000000", "0123456789abcdeg", body},
+		{"body", "secret-1", "POST", "/api/post-vote", "1700000000", "0123456789abcdef", []byte(`{"student_id":"2021102615"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if KioskSignature(tt.secret, tt.method, tt.path, tt.timestamp, tt.nonce, tt.body) == base {
+				t.Errorf("changing the %s did not change the signature", tt.name)
+			}
+		})
+	}
+}
+
+func TestAuthenticateKiosk(t *testing.T) {
+	useKioskTestDB(t)
+
+	body := []byte(`{"student_id":"2021102614"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*kioskSignatureSkew).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(2*kioskSignatureSkew).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		kioskID   string
+		secret    string
+		timestamp string
+		nonce     string
+		unsigned  bool
+		uppercase bool
+		body      []byte
+		want      bool
+	}{
+		{name: "valid", kioskID: "kiosk-1", secret: "secret-1", timestamp: now, nonce: "nonce-valid-0001", body: body, want: true},
+		{name: "uppercase signature", kioskID: "kiosk-1", secret: "secret-1", timestamp: now, nonce: "nonce-upper-0001", uppercase: true, body: body, want: true},
+		{name: "wrong secret", kioskID: "kiosk-1", secret: "secret-2", timestamp: now, nonce: "nonce-wrong-0001", body: body},
+		{name: "unknown kiosk", kioskID: "kiosk-2", secret: "secret-1", timestamp: now, nonce: "nonce-unknown-01", body: body},
+		{name: "revoked kiosk", kioskID: "kiosk-revoked", secret: "secret-revoked", timestamp: now, nonce: "nonce-revoked-01", body: body},
+		{name: "stale timestamp", kioskID: "kiosk-1", secret: "secret-1", timestamp: stale, nonce: "nonce-stale-0001", body: body},
+		{name: "future timestamp", kioskID: "kiosk-1", secret: "secret-1", timestamp: future, nonce: "nonce-future-001", body: body},
+		{name: "malformed timestamp", kioskID: "kiosk-1", secret: "secret-1", timestamp: "yesterday", nonce: "nonce-malformed1", body: body},
+		{name: "short nonce", kioskID: "kiosk-1", secret: "secret-1", timestamp: now, nonce: "short", body: body},
+		{name: "long nonce", kioskID: "kiosk-1", secret: "secret-1", timestamp: now, nonce: strings.Repeat("n", 65), body: body},
+		{name: "missing signature", kioskID: "kiosk-1", timestamp: now, nonce: "nonce-missing-01", unsigned: true, body: body},
+		{name: "tampered body", kioskID: "kiosk-1", secret: "secret-1", timestamp: now, nonce: "nonce-tamper-001", body: []byte(`{"student_id":"2021102615"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature := KioskSignature(tt.secret, "POST", "/api/post-vote", tt.timestamp, tt.nonce, body)
+			if tt.uppercase {
+				signature = strings.ToUpper(signature)
+			}
+			if tt.unsigned {
+				signature = ""
+			}
+
+			if got := AuthenticateKiosk(tt.kioskID, tt.timestamp, tt.nonce, signature, "POST", "/api/post-vote", tt.body); got != tt.want {
+				t.Errorf("AuthenticateKiosk returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateKioskRejectsReplays(t *testing.T) {
+	useKioskTestDB(t)
+
+	body := []byte(`{"student_id":"2021102614"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := "nonce-replay-0001"
+	signature := KioskSignature("secret-1", "POST", "/api/post-vote", timestamp, nonce, body)
+
+	if !AuthenticateKiosk("kiosk-1", timestamp, nonce, signature, "POST", "/api/post-vote", body) {
+		t.Fatal("first request was rejected")
+	}
+	if AuthenticateKiosk("kiosk-1", timestamp, nonce, signature, "POST", "/api/post-vote", body) {
+		t.Error("replayed request was accepted")
+	}
+
+	// A fresh nonce signs a new request
+	fresh := "nonce-replay-0002"
+	if !AuthenticateKiosk("kiosk-1", timestamp, fresh, KioskSignature("secret-1", "POST", "/api/post-vote", timestamp, fresh, body), "POST", "/api/post-vote", body) {
+		t.Error("request with a fresh nonce was rejected")
+	}
+}
+
+func TestUseKioskNonce(t *testing.T) {
+	start := time.Now()
+
+	if !useKioskNonce("kiosk-a", "nonce-prune-00001", start) {
+		t.Fatal("first use was refused")
+	}
+	if useKioskNonce("kiosk-a", "nonce-prune-00001", start.Add(kioskSignatureSkew)) {
+		t.Error("nonce was accepted again inside the signature window")
+	}
+	if !useKioskNonce("kiosk-b", "nonce-prune-00001", start) {
+		t.Error("another kiosk's nonce was refused")
+	}
+	// Once its timestamp can no longer pass the skew check the nonce is forgotten
+	if !useKioskNonce("kiosk-a", "nonce-prune-00001", start.Add(2*kioskSignatureSkew+time.Second)) {
+		t.Error("nonce was not pruned after the signature window")
+	}
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// Each backup's manifest is stored beside it under the backup's name with this suffix
+const manifestSuffix = ".manifest.json"
+
+// ManifestKey is the storage key of a backup's manifest
+func ManifestKey(filename string) string {
+	return filename + manifestSuffix
+}
+
+// IsManifestKey reports whether a key in backup storage is a manifest rather than a backup
+func IsManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix)
+}
+
+func appVersion() string {
+	if revel.Config != nil {
+		return revel.Config.StringDefault("app.version", "unknown")
+	}
+	return "unknown"
+}
+
+// backupElectionState records where the election stood when a backup was made
+func backupElectionState() models.BackupElectionState {
+	state := models.BackupElectionState{
+		Open:      ElectionOpen(),
+		Certified: ElectionCertified(),
+	}
+
+	var votingStart, votingEnd sql.NullString
+	err := DB.QueryRow(`SELECT voting_start, voting_end FROM election_settings LIMIT 1`).Scan(&votingStart, &votingEnd)
+	if err != nil && err != sql.ErrNoRows {
+		revel.AppLog.Errorf("Failed to fetch election settings: %v", err)
+	}
+	state.VotingStart = votingStart.String
+	state.VotingEnd = votingEnd.String
+
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM ballots`).Scan(&state.BallotsCast); err != nil {
+		revel.AppLog.Errorf("Failed to count ballots: %v", err)
+	}
+
+	return state
+}
+
+func newBackupManifest(filename, checksum string, size int64, trigger, username string, rowCounts map[string]int) models.BackupManifest {
+	return models.BackupManifest{
+		Filename:   filename,
+		SHA256:     checksum,
+		Size:       size,
+		CreatedAt:  time.Now().Format(time.RFC3339),
+		CreatedBy:  username,
+		Trigger:    trigger,
+		AppVersion: appVersion(),
+		RowCounts:  rowCounts,
+		Election:   backupElectionState(),
+	}
+}
+
+func saveBackupManifest(manifest models.BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return PutBytes(Backups, ManifestKey(manifest.Filename), data, "application/json")
+}
+
+// GetBackupManifest reads a backup's manifest. Backups made before manifests existed have none, and
+// nil is returned for them.
+func GetBackupManifest(filename string) (*models.BackupManifest, error) {
+	reader, err := Backups.Get(ManifestKey(filename))
+	if err == ErrObjectNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var manifest models.BackupManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("manifest of %s is not valid JSON: %w", filename, err)
+	}
+	return &manifest, nil
+}
+
+// ListBackups lists the stored backups, newest first, with their manifests and signed download links.
+// Backups stored before encryption was required are listed with Encrypted false.
+func ListBackups() ([]models.BackupListEntry, error) {
+	objects, err := Backups.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ModTime.After(objects[j].ModTime)
+	})
+
+	backups := []models.BackupListEntry{}
+	for _, object := range objects {
+		if IsManifestKey(object.Key) {
+			continue
+		}
+
+		downloadURL, expiresAt := BackupDownloadURL(object.Key)
+		manifest, err := GetBackupManifest(object.Key)
+		if err != nil {
+			revel.AppLog.Errorf("Failed to read manifest of %s: %v", object.Key, err)
+		}
+		encrypted, err := IsBackupEncrypted(object.Key)
+		if err != nil {
+			revel.AppLog.Errorf("Failed to read %s: %v", object.Key, err)
+		}
+
+		backups = append(backups, models.BackupListEntry{
+			Filename:    object.Key,
+			DownloadURL: downloadURL,
+			ExpiresAt:   expiresAt.Format(time.RFC3339),
+			Size:        object.Size,
+			ModifiedAt:  object.ModTime.Format(time.RFC3339),
+			Encrypted:   encrypted,
+			Manifest:    manifest,
+		})
+	}
+
+	return backups, nil
+}
+
+// DeleteBackup removes a backup and its manifest
+func DeleteBackup(filename string) error {
+	if err := Backups.Delete(filename); err != nil {
+		return err
+	}
+	if err := Backups.Delete(ManifestKey(filename)); err != nil && err != ErrObjectNotFound {
+		return err
+	}
+	return nil
+}
+
+// VerifyBackup checks a backup against its manifest: its size and SHA-256 must match, and the export
+// must still decrypt and open with the row count the manifest records for each sheet or table
+func VerifyBackup(filename string) (models.BackupVerification, error) {
+	verification := models.BackupVerification{Filename: filename, Problems: []string{}}
+
+	data, err := readStoredBackup(filename)
+	if err != nil {
+		return verification, err
+	}
+
+	sum := sha256.Sum256(data)
+	verification.SHA256 = hex.EncodeToString(sum[:])
+	verification.Size = int64(len(data))
+
+	manifest, err := GetBackupManifest(filename)
+	if err != nil {
+		verification.Problems = append(verification.Problems, err.Error())
+	}
+	verification.HasManifest = manifest != nil
+
+	if manifest == nil {
+		if err == nil {
+			verification.Problems = append(verification.Problems, "backup has no manifest; it was made before manifests were recorded and cannot be checked")
+		}
+	} else {
+		if manifest.Filename != filename {
+			verification.Problems = append(verification.Problems, fmt.Sprintf("manifest describes %s, not this backup", manifest.Filename))
+		}
+		if manifest.Size != verification.Size {
+			verification.Problems = append(verification.Problems, fmt.Sprintf("file is %d bytes but the manifest records %d; it was truncated or modified", verification.Size, manifest.Size))
+		}
+		if manifest.SHA256 != verification.SHA256 {
+			verification.Problems = append(verification.Problems, "SHA-256 does not match the manifest; the file was modified")
+		}
+		if manifest.Encrypted != isEncryptedBackup(data) {
+			verification.Problems = append(verification.Problems, "encryption does not match the manifest")
+		}
+	}
+
+	plaintext, err := DecryptBackup(data)
+	if err != nil {
+		verification.Problems = append(verification.Problems, err.Error())
+		return verification, nil
+	}
+
+	format := ""
+	if manifest != nil {
+		format = manifest.Format
+	}
+	counts, err := exportRowCounts(format, plaintext)
+	if err != nil {
+		verification.Problems = append(verification.Problems, err.Error())
+	} else if manifest != nil {
+		names := make([]string, 0, len(manifest.RowCounts))
+		for name := range manifest.RowCounts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			count, ok := counts[name]
+			if !ok {
+				verification.Problems = append(verification.Problems, fmt.Sprintf("%s is missing", name))
+				continue
+			}
+			if count != manifest.RowCounts[name] {
+				verification.Problems = append(verification.Problems, fmt.Sprintf("%s has %d rows but the manifest records %d", name, count, manifest.RowCounts[name]))
+			}
+		}
+	}
+
+	verification.Valid = len(verification.Problems) == 0
+	return verification, nil
+}
@@ -0,0 +1,322 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// Candidates without a partylist are shown under this name
+const IndependentPartylist = "Independent"
+
+// ErrPartylistInUse is returned when deleting a partylist that still has candidates
+var ErrPartylistInUse = errors.New("partylist still has candidates")
+
+// ErrUnknownPartylist is returned when a candidate names a partylist that does not exist
+var ErrUnknownPartylist = errors.New("unknown partylist")
+
+var partylistColor = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func GetPartylists() ([]models.Partylist, error) {
+	rows, err := DB.Query(`SELECT id, name, COALESCE(acronym, ''), COALESCE(logo_url, ''), COALESCE(platform, ''), COALESCE(color, '') FROM partylists ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partylists := []models.Partylist{}
+	for rows.Next() {
+		var partylist models.Partylist
+		if err := rows.Scan(&partylist.ID, &partylist.Name, &partylist.Acronym, &partylist.LogoURL, &partylist.Platform, &partylist.Color); err != nil {
+			return nil, err
+		}
+		partylists = append(partylists, partylist)
+	}
+
+	return partylists, nil
+}
+
+// ValidatePartylist checks the fields an admin can set on a partylist
+func ValidatePartylist(partylist models.Partylist) error {
+	name := strings.TrimSpace(partylist.Name)
+	if name == "" {
+		return fmt.Errorf("partylist name is required")
+	}
+	if strings.EqualFold(name, IndependentPartylist) {
+		return fmt.Errorf("%s is reserved for candidates without a partylist", IndependentPartylist)
+	}
+	if len(name) > 50 {
+		return fmt.Errorf("partylist name must be at most 50 characters")
+	}
+	if partylist.Color != "" && !partylistColor.MatchString(partylist.Color) {
+		return fmt.Errorf("color must be a hex value such as #1A2B3C")
+	}
+	return nil
+}
+
+// SavePartylist creates a partylist, or updates it when ID is set, and returns its ID.
+// Renaming a partylist also renames it on its candidates.
+func SavePartylist(q queryer, partylist models.Partylist) (int64, error) {
+	partylist.Name = strings.TrimSpace(partylist.Name)
+
+	if partylist.ID == 0 {
+		result, err := q.Exec(`INSERT INTO partylists (name, acronym, logo_url, platform, color) VALUES (?, ?, ?, ?, ?)`,
+			partylist.Name, partylist.Acronym, partylist.LogoURL, partylist.Platform, partylist.Color)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+
+	result, err := q.Exec(`UPDATE partylists SET name = ?, acronym = ?, logo_url = ?, platform = ?, color = ? WHERE id = ?`,
+		partylist.Name, partylist.Acronym, partylist.LogoURL, partylist.Platform, partylist.Color, partylist.ID)
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		var exists int
+		if err := q.QueryRow(`SELECT COUNT(*) FROM partylists WHERE id = ?`, partylist.ID).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists == 0 {
+			return 0, sql.ErrNoRows
+		}
+	}
+
+	if _, err := q.Exec(`UPDATE candidates SET partylist = ? WHERE partylist_id = ?`, partylist.Name, partylist.ID); err != nil {
+		return 0, err
+	}
+	return partylist.ID, nil
+}
+
+// DeletePartylist removes a partylist that no candidate belongs to
+func DeletePartylist(id int64) error {
+	var candidates int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM candidates WHERE partylist_id = ?`, id).Scan(&candidates); err != nil {
+		return err
+	}
+	if candidates > 0 {
+		return ErrPartylistInUse
+	}
+
+	result, err := DB.Exec(`DELETE FROM partylists WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ResolvePartylist finds the partylist a candidate belongs to, either by ID or by name or acronym.
+// An empty name or "Independent" means no partylist; any other unknown name is ErrUnknownPartylist.
+// It returns the partylist ID (nil for independents) and the name stored on the candidate.
+func ResolvePartylist(q queryer, id *int64, name string) (*int64, string, error) {
+	if id != nil {
+		var partylistName string
+		err := q.QueryRow(`SELECT name FROM partylists WHERE id = ?`, *id).Scan(&partylistName)
+		if err == sql.ErrNoRows {
+			return nil, "", fmt.Errorf("%w: partylist %d does not exist", ErrUnknownPartylist, *id)
+		}
+		return id, partylistName, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, IndependentPartylist) {
+		return nil, IndependentPartylist, nil
+	}
+
+	var partylistID int64
+	var partylistName string
+	err := q.QueryRow(`SELECT id, name FROM partylists WHERE name = ? OR acronym = ? LIMIT 1`, name, name).Scan(&partylistID, &partylistName)
+	if err == sql.ErrNoRows {
+		return nil, "", fmt.Errorf("%w: %q", ErrUnknownPartylist, name)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &partylistID, partylistName, nil
+}
+
+// MergePartylist saves a partylist submitted by name: a new name creates it, and an existing one is
+// updated with the fields that were submitted, keeping the stored value of any left empty
+func MergePartylist(q queryer, partylist models.Partylist) (int64, error) {
+	partylist.Name = strings.TrimSpace(partylist.Name)
+
+	var stored models.Partylist
+	err := q.QueryRow(`SELECT id, COALESCE(acronym, ''), COALESCE(logo_url, ''), COALESCE(platform, ''), COALESCE(color, '') FROM partylists WHERE name = ?`,
+		partylist.Name).Scan(&stored.ID, &stored.Acronym, &stored.LogoURL, &stored.Platform, &stored.Color)
+	if err == sql.ErrNoRows {
+		partylist.ID = 0
+		return SavePartylist(q, partylist)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	partylist.ID = stored.ID
+	if partylist.Acronym == "" {
+		partylist.Acronym = stored.Acronym
+	}
+	if partylist.LogoURL == "" {
+		partylist.LogoURL = stored.LogoURL
+	}
+	if partylist.Platform == "" {
+		partylist.Platform = stored.Platform
+	}
+	if partylist.Color == "" {
+		partylist.Color = stored.Color
+	}
+	return SavePartylist(q, partylist)
+}
+
+// GetPartylistSlates lists every partylist with its candidates ordered by position, followed by the independents
+func GetPartylistSlates() ([]models.PartylistSlate, error) {
+	partylists, err := GetPartylists()
+	if err != nil {
+		return nil, err
+	}
+
+	slates := make([]models.PartylistSlate, 0, len(partylists)+1)
+	index := make(map[int64]int)
+	for _, partylist := range partylists {
+		id := partylist.ID
+		index[id] = len(slates)
+		slates = append(slates, models.PartylistSlate{
+			PartylistID: &id,
+			Name:        partylist.Name,
+			Acronym:     partylist.Acronym,
+			Color:       partylist.Color,
+			Candidates:  []models.Candidate{},
+		})
+	}
+	independents := models.PartylistSlate{Name: IndependentPartylist, Candidates: []models.Candidate{}}
+
+	rows, err := DB.Query(`SELECT id, position_name, name, position, COALESCE(year_level, ''), COALESCE(program, ''), COALESCE(photo_url, ''), partylist, partylist_id FROM candidates WHERE withdrawn_at IS NULL ORDER BY position, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var candidate models.Candidate
+		var partylistID sql.NullInt64
+		err := rows.Scan(&candidate.ID, &candidate.PositionName, &candidate.Name, &candidate.Position, &candidate.YearLevel,
+			&candidate.Program, &candidate.PhotoURL, &candidate.Partylist, &partylistID)
+		if err != nil {
+			return nil, err
+		}
+
+		i, ok := index[partylistID.Int64]
+		if !partylistID.Valid || !ok {
+			independents.Candidates = append(independents.Candidates, candidate)
+			continue
+		}
+		candidate.PartylistID = slates[i].PartylistID
+		slates[i].Candidates = append(slates[i].Candidates, candidate)
+	}
+
+	if len(independents.Candidates) > 0 {
+		slates = append(slates, independents)
+	}
+	return slates, nil
+}
+
+// GetPartylistResults aggregates candidate votes and seats won per partylist, with independents grouped together
+func GetPartylistResults() ([]models.PartylistResult, error) {
+	outcomes, err := ComputeOutcomes()
+	if err != nil {
+		return nil, err
+	}
+	won := make(map[string]bool)
+	for _, winner := range FinalWinners(outcomes) {
+		won[winner.PositionName] = true
+	}
+
+	rows, err := DB.Query(`
+		SELECT c.position_name, c.name, c.partylist_id, COALESCE(p.name, ?), COALESCE(p.color, ''), COALESCE(v.total_votes, 0)
+		FROM candidates c
+		LEFT JOIN partylists p ON p.id = c.partylist_id
+		LEFT JOIN votes v ON v.candidate_id = c.id
+		ORDER BY p.name
+	`, IndependentPartylist)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.PartylistResult{}
+	index := make(map[string]int)
+	totalVotes := 0
+	for rows.Next() {
+		var positionName, name, partylistName, color string
+		var partylistID sql.NullInt64
+		var votes int
+		if err := rows.Scan(&positionName, &name, &partylistID, &partylistName, &color, &votes); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[partylistName]
+		if !ok {
+			i = len(results)
+			index[partylistName] = i
+			result := models.PartylistResult{Name: partylistName, Color: color, Winners: []string{}}
+			if partylistID.Valid {
+				id := partylistID.Int64
+				result.PartylistID = &id
+			}
+			results = append(results, result)
+		}
+
+		results[i].Candidates++
+		results[i].TotalVotes += votes
+		if won[positionName] {
+			results[i].SeatsWon++
+			results[i].Winners = append(results[i].Winners, name)
+		}
+		totalVotes += votes
+	}
+
+	for i := range results {
+		results[i].VoteShare = Percentage(results[i].TotalVotes, totalVotes)
+	}
+	return results, nil
+}
+
+// Fetch Partylists
+func GetPartylistsData() [][]string {
+	partylists, err := GetPartylists()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to fetch partylists: %v", err)
+		return nil
+	}
+
+	data := [][]string{
+		{"ID", "Name", "Acronym", "Logo URL", "Platform", "Color"},
+	}
+	for _, partylist := range partylists {
+		data = append(data, []string{
+			fmt.Sprintf("%d", partylist.ID),
+			SanitizeCellValue(partylist.Name),
+			SanitizeCellValue(partylist.Acronym),
+			SanitizeCellValue(partylist.LogoURL),
+			SanitizeCellValue(partylist.Platform),
+			SanitizeCellValue(partylist.Color),
+		})
+	}
+
+	return data
+}
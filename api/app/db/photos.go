@@ -0,0 +1,283 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"github.com/revel/revel"
+)
+
+// Decoded images larger than this many pixels are refused before decoding, whatever their file size
+const maxPhotoPixels = 40000000
+
+var (
+	// ErrPhotoTooLarge is returned when an upload exceeds photo.max.bytes
+	ErrPhotoTooLarge = errors.New("photo is too large")
+	// ErrPhotoRejected is wrapped by every reason an upload is not an acceptable image
+	ErrPhotoRejected = errors.New("photo rejected")
+)
+
+// Content types accepted for candidate photos, as sniffed from the file contents
+var allowedPhotoTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// ProcessedPhoto holds the re-encoded standard and thumbnail variants of an upload, named by content hash
+type ProcessedPhoto struct {
+	Filename          string
+	ThumbnailFilename string
+	Image             []byte
+	Thumbnail         []byte
+}
+
+func photoConfig(key string, fallback int) int {
+	if revel.Config != nil {
+		return revel.Config.IntDefault(key, fallback)
+	}
+	return fallback
+}
+
+// MaxPhotoBytes is the largest photo upload accepted
+func MaxPhotoBytes() int64 {
+	return int64(photoConfig("photo.max.bytes", 5<<20))
+}
+
+// ProcessPhoto validates an uploaded photo and re-encodes it. Only the decoded pixels survive, so EXIF
+// metadata and anything appended to the file are dropped. The EXIF orientation is applied first so
+// photos taken on phones stay upright.
+func ProcessPhoto(r io.Reader) (ProcessedPhoto, error) {
+	var photo ProcessedPhoto
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxPhotoBytes()+1))
+	if err != nil {
+		return photo, err
+	}
+	if int64(len(data)) > MaxPhotoBytes() {
+		return photo, ErrPhotoTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if !allowedPhotoTypes[contentType] {
+		return photo, fmt.Errorf("%w: %s is not an accepted image type", ErrPhotoRejected, contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return photo, fmt.Errorf("%w: %v", ErrPhotoRejected, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPhotoPixels {
+		return photo, fmt.Errorf("%w: image dimensions %dx%d are not allowed", ErrPhotoRejected, config.Width, config.Height)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return photo, fmt.Errorf("%w: %v", ErrPhotoRejected, err)
+	}
+	if contentType == "image/jpeg" {
+		decoded = applyOrientation(decoded, jpegOrientation(data))
+	}
+
+	standard := fitWithin(decoded, photoConfig("photo.max.dimension", 800))
+	thumbnailSize := photoConfig("photo.thumbnail.size", 200)
+	thumbnail := resizeImage(squareCrop(decoded), thumbnailSize, thumbnailSize)
+
+	if photo.Image, err = encodePhoto(standard); err != nil {
+		return photo, err
+	}
+	if photo.Thumbnail, err = encodePhoto(thumbnail); err != nil {
+		return photo, err
+	}
+
+	// Both variants are named after the standard image's content so they stay paired
+	sum := sha256.Sum256(photo.Image)
+	hash := hex.EncodeToString(sum[:16])
+	photo.Filename = hash + ".jpg"
+	photo.ThumbnailFilename = hash + "_thumb.jpg"
+
+	return photo, nil
+}
+
+// encodePhoto flattens transparency onto white and encodes as JPEG
+func encodePhoto(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fitWithin scales img down so its longest side is at most maxSide, keeping the aspect ratio
+func fitWithin(img image.Image, maxSide int) image.Image {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+	if width >= height {
+		return resizeImage(img, maxSide, max(1, height*maxSide/width))
+	}
+	return resizeImage(img, max(1, width*maxSide/height), maxSide)
+}
+
+// squareCrop returns the centered square of img
+func squareCrop(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Point{X: x, Y: y}, draw.Src)
+	return square
+}
+
+// resizeImage scales img to width x height by averaging the source pixels each destination pixel covers.
+// Box filtering is only used for downscaling photos, where it gives smooth results without a dependency.
+func resizeImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max((y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max((x+1)*srcWidth/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) from a JPEG, returning 1 when it is absent
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset : offset+2]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and mirrors img so it displays upright without its EXIF orientation tag
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	transposed := orientation >= 5
+	dstWidth, dstHeight := width, height
+	if transposed {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// PhotoInUse reports whether any candidate still refers to the given photo or thumbnail file
+func PhotoInUse(filename string) (bool, error) {
+	var count int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM candidates WHERE photo_url = ? OR thumbnail_url = ?`, filename, filename).Scan(&count)
+	return count > 0, err
+}
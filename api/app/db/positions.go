@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"api/app/models"
+)
+
+// ErrPositionLocked is returned when a position's settings would change after voting has started
+var ErrPositionLocked = errors.New("position cannot be changed")
+
+const (
+	TabulationPlurality    = "plurality"
+	TabulationRankedChoice = "irv"
+)
+
+// GetPositions lists every position that has candidates or settings. Positions without settings elect one
+// candidate by plurality with one selection per ballot.
+func GetPositions() ([]models.Position, error) {
+	rows, err := DB.Query(`
+		SELECT p.position, COALESCE(s.seats, 1), COALESCE(s.max_selections, 1), COALESCE(s.tabulation, 'plurality')
+		FROM (
+			SELECT DISTINCT position FROM candidates
+			UNION
+			SELECT position FROM positions
+		) p
+		LEFT JOIN positions s ON s.position = p.position
+		ORDER BY p.position
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := []models.Position{}
+	for rows.Next() {
+		var position models.Position
+		if err := rows.Scan(&position.Position, &position.Seats, &position.MaxSelections, &position.Tabulation); err != nil {
+			return nil, err
+		}
+		positions = append(positions, position)
+	}
+
+	return positions, nil
+}
+
+// positionRules maps each configured position to its settings
+func positionRules() (map[string]models.Position, error) {
+	positions, err := GetPositions()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make(map[string]models.Position)
+	for _, position := range positions {
+		rules[position.Position] = position
+	}
+	return rules, nil
+}
+
+// ruleFor returns the settings of a position, defaulting to a single seat
+func ruleFor(rules map[string]models.Position, position string) models.Position {
+	if rule, ok := rules[position]; ok {
+		return rule
+	}
+	return models.Position{Position: position, Seats: 1, MaxSelections: 1, Tabulation: TabulationPlurality}
+}
+
+// ValidatePosition checks that a position's seat, selection and tabulation settings make sense.
+// An empty tabulation defaults to plurality.
+func ValidatePosition(position *models.Position) error {
+	if position.Position == "" {
+		return fmt.Errorf("position name is required")
+	}
+	if position.Seats < 1 {
+		return fmt.Errorf("%s must have at least one seat", position.Position)
+	}
+
+	switch position.Tabulation {
+	case "", TabulationPlurality:
+		position.Tabulation = TabulationPlurality
+		if position.MaxSelections < 1 || position.MaxSelections > position.Seats {
+			return fmt.Errorf("%s must allow between 1 and %d selections", position.Position, position.Seats)
+		}
+	case TabulationRankedChoice:
+		// Instant runoff elects a single winner; max_selections is how many preferences a ballot may rank
+		if position.Seats != 1 {
+			return fmt.Errorf("%s uses ranked-choice and must have exactly one seat", position.Position)
+		}
+		if position.MaxSelections < 1 {
+			return fmt.Errorf("%s must allow at least one ranked preference", position.Position)
+		}
+	default:
+		return fmt.Errorf("%s has an unknown tabulation %q", position.Position, position.Tabulation)
+	}
+	return nil
+}
+
+// SavePositions creates or updates position settings in one transaction. Once voting has started a
+// position's seats, selections and tabulation are fixed, since ballots already cast were checked against them.
+func SavePositions(positions []models.Position) error {
+	started, err := VotingStarted()
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, position := range positions {
+		if started {
+			current := models.Position{Position: position.Position, Seats: 1, MaxSelections: 1, Tabulation: TabulationPlurality}
+			err := tx.QueryRow(`SELECT seats, max_selections, tabulation FROM positions WHERE position = ? FOR UPDATE`,
+				position.Position).Scan(&current.Seats, &current.MaxSelections, &current.Tabulation)
+			if err != nil && err != sql.ErrNoRows {
+				tx.Rollback()
+				return err
+			}
+			if current != position {
+				tx.Rollback()
+				return fmt.Errorf("%w: voting has started, so the settings of %s can no longer change", ErrPositionLocked, position.Position)
+			}
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO positions (position, seats, max_selections, tabulation)
+			VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				seats = VALUES(seats),
+				max_selections = VALUES(max_selections),
+				tabulation = VALUES(tabulation)
+		`, position.Position, position.Seats, position.MaxSelections, position.Tabulation)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// candidatePositions maps every candidate still in the race to the position they run for. Withdrawn
+// candidates are left out, so ranked-choice ballots naming them count for their next preference.
+func candidatePositions() (map[string]string, error) {
+	rows, err := DB.Query(`SELECT v.position_name, v.position FROM votes v
+		LEFT JOIN candidates c ON c.id = v.candidate_id
+		WHERE c.withdrawn_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	positions := make(map[string]string)
+	for rows.Next() {
+		var positionName, position string
+		if err := rows.Scan(&positionName, &position); err != nil {
+			return nil, err
+		}
+		positions[positionName] = position
+	}
+
+	return positions, nil
+}
@@ -0,0 +1,310 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// Ballots are only ever added while an election runs, so their parsed selections are kept between
+// results recomputes and only ballots cast since the last read are fetched
+var rankedBallotCache = struct {
+	sync.Mutex
+	seen       map[string]bool
+	selections []map[string][]string
+	lastCastAt string
+}{}
+
+// ballotSelections returns the per-position selections of every ballot cast. The cache is rebuilt
+// whenever the ballot count disagrees with it, as after a reset or restore.
+func ballotSelections() ([]map[string][]string, error) {
+	rankedBallotCache.Lock()
+	defer rankedBallotCache.Unlock()
+
+	if rankedBallotCache.seen != nil {
+		if err := readNewBallots(); err != nil {
+			return nil, err
+		}
+
+		var count int
+		if err := DB.QueryRow(`SELECT COUNT(*) FROM ballots`).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count == len(rankedBallotCache.seen) {
+			return rankedBallotCache.selections, nil
+		}
+	}
+
+	// A ballot committed during the full read is picked up, or the cache rebuilt again, on the next call
+	rankedBallotCache.seen = make(map[string]bool)
+	rankedBallotCache.selections = nil
+	rankedBallotCache.lastCastAt = ""
+	if err := readNewBallots(); err != nil {
+		rankedBallotCache.seen = nil
+		return nil, err
+	}
+	return rankedBallotCache.selections, nil
+}
+
+// invalidateBallotCache makes the next read rebuild the cache, for when stored ballots are replaced
+// rather than added to
+func invalidateBallotCache() {
+	rankedBallotCache.Lock()
+	rankedBallotCache.seen = nil
+	rankedBallotCache.Unlock()
+}
+
+// readNewBallots adds the ballots cast since the last read to the cache. Ballots cast in the same
+// second as the last one read are fetched again and skipped by ID.
+func readNewBallots() error {
+	query := `SELECT ballot_id, selections, cast_at FROM ballots ORDER BY cast_at`
+	args := []interface{}{}
+	if rankedBallotCache.lastCastAt != "" {
+		query = `SELECT ballot_id, selections, cast_at FROM ballots WHERE cast_at >= ? ORDER BY cast_at`
+		args = append(args, rankedBallotCache.lastCastAt)
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ballotID, selectionsJSON, castAt string
+		if err := rows.Scan(&ballotID, &selectionsJSON, &castAt); err != nil {
+			return err
+		}
+		rankedBallotCache.lastCastAt = castAt
+		if rankedBallotCache.seen[ballotID] {
+			continue
+		}
+		rankedBallotCache.seen[ballotID] = true
+
+		var selections map[string][]string
+		if err := json.Unmarshal([]byte(selectionsJSON), &selections); err != nil {
+			// Ballots stored before per-position selections carry no rankings
+			continue
+		}
+		rankedBallotCache.selections = append(rankedBallotCache.selections, selections)
+	}
+
+	return rows.Err()
+}
+
+// rankedBallots reads the preference lists cast for a position from the anonymous ballot records.
+// Preferences are stored as candidate IDs and returned as position_names for tabulation.
+func rankedBallots(position string) ([][]string, error) {
+	index, err := loadCandidateIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	allSelections, err := ballotSelections()
+	if err != nil {
+		return nil, err
+	}
+
+	var ballots [][]string
+	for _, selections := range allSelections {
+		var preferences []string
+		for _, ref := range selections[position] {
+			if candidate, ok := index.resolve(ref); ok {
+				preferences = append(preferences, candidate.PositionName)
+			}
+		}
+		if len(preferences) > 0 {
+			ballots = append(ballots, preferences)
+		}
+	}
+
+	return ballots, nil
+}
+
+// TabulateRankedChoice runs instant-runoff rounds. Each round counts every ballot for its highest ranked
+// candidate still in the race; a candidate with a majority of the continuing ballots wins, otherwise the
+// candidates with the fewest votes are eliminated together. If all remaining candidates are level the
+// result is a tie to be settled through the tie-break workflow.
+func TabulateRankedChoice(position string, candidates []string, ballots [][]string) models.RankedChoiceResult {
+	result := models.RankedChoiceResult{Position: position, Rounds: []models.RankedChoiceRound{}}
+
+	active := make(map[string]bool)
+	for _, candidate := range candidates {
+		active[candidate] = true
+	}
+
+	for round := 1; len(active) > 0; round++ {
+		current := models.RankedChoiceRound{Round: round, Counts: make(map[string]int), Eliminated: []string{}}
+		for candidate := range active {
+			current.Counts[candidate] = 0
+		}
+
+		for _, preferences := range ballots {
+			counted := false
+			for _, candidate := range preferences {
+				if active[candidate] {
+					current.Counts[candidate]++
+					counted = true
+					break
+				}
+			}
+			if !counted {
+				current.Exhausted++
+			}
+		}
+
+		continuing := len(ballots) - current.Exhausted
+		highest, lowest := -1, -1
+		for _, count := range current.Counts {
+			if highest == -1 || count > highest {
+				highest = count
+			}
+			if lowest == -1 || count < lowest {
+				lowest = count
+			}
+		}
+
+		var leaders []string
+		for candidate, count := range current.Counts {
+			if count == highest {
+				leaders = append(leaders, candidate)
+			}
+		}
+		sort.Strings(leaders)
+
+		if len(active) == 1 || (len(leaders) == 1 && highest*2 > continuing) {
+			current.Winner = leaders[0]
+			result.Winner = leaders[0]
+			result.Rounds = append(result.Rounds, current)
+			return result
+		}
+
+		if highest == lowest {
+			result.Tied = leaders
+			result.Rounds = append(result.Rounds, current)
+			return result
+		}
+
+		for candidate, count := range current.Counts {
+			if count == lowest {
+				current.Eliminated = append(current.Eliminated, candidate)
+				delete(active, candidate)
+			}
+		}
+		sort.Strings(current.Eliminated)
+		result.Rounds = append(result.Rounds, current)
+	}
+
+	return result
+}
+
+// rankedChoiceStandings converts instant-runoff rounds into candidates carrying the votes they held in the
+// last round they took part in, which ranks the winner first and tied finalists level.
+func rankedChoiceStandings(candidates []models.RankedCandidate, result models.RankedChoiceResult) []models.RankedCandidate {
+	last := make(map[string]int)
+	for _, round := range result.Rounds {
+		for positionName, count := range round.Counts {
+			last[positionName] = count
+		}
+	}
+
+	// Withdrawn candidates take no part in the rounds and keep the votes they were counted
+	standings := make([]models.RankedCandidate, len(candidates))
+	for i, candidate := range candidates {
+		if count, ok := last[candidate.PositionName]; ok {
+			candidate.TotalVotes = count
+		}
+		standings[i] = candidate
+	}
+	return standings
+}
+
+// GetRankedChoiceResults tabulates every ranked-choice position
+func GetRankedChoiceResults() ([]models.RankedChoiceResult, error) {
+	rules, err := positionRules()
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := candidatePositions()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []models.RankedChoiceResult{}
+	for _, rule := range rules {
+		if rule.Tabulation != TabulationRankedChoice {
+			continue
+		}
+
+		var positionNames []string
+		for positionName, position := range candidates {
+			if position == rule.Position {
+				positionNames = append(positionNames, positionName)
+			}
+		}
+		sort.Strings(positionNames)
+
+		ballots, err := rankedBallots(rule.Position)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, TabulateRankedChoice(rule.Position, positionNames, ballots))
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Position < results[j].Position })
+	return results, nil
+}
+
+// Fetch Ranked Choice Rounds
+func GetRankedChoiceData() [][]string {
+	results, err := GetRankedChoiceResults()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to tabulate ranked-choice positions: %v", err)
+		return nil
+	}
+
+	data := [][]string{
+		{"Position", "Round", "Candidate", "Votes", "Status", "Exhausted Ballots"},
+	}
+	for _, result := range results {
+		for _, round := range result.Rounds {
+			eliminated := make(map[string]bool)
+			for _, positionName := range round.Eliminated {
+				eliminated[positionName] = true
+			}
+
+			var positionNames []string
+			for positionName := range round.Counts {
+				positionNames = append(positionNames, positionName)
+			}
+			sort.Strings(positionNames)
+
+			for _, positionName := range positionNames {
+				status := "Continuing"
+				if eliminated[positionName] {
+					status = "Eliminated"
+				} else if round.Winner == positionName {
+					status = "Winner"
+				} else if round.Winner == "" && len(round.Eliminated) == 0 {
+					status = "Tied"
+				}
+
+				data = append(data, []string{
+					SanitizeCellValue(result.Position),
+					fmt.Sprintf("%d", round.Round),
+					SanitizeCellValue(positionName),
+					fmt.Sprintf("%d", round.Counts[positionName]),
+					status,
+					fmt.Sprintf("%d", round.Exhausted),
+				})
+			}
+		}
+	}
+
+	return data
+}
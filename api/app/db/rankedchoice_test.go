@@ -0,0 +1,153 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+// repeat returns n copies of a ballot's preferences
+func repeat(n int, preferences ...string) [][]string {
+	ballots := make([][]string, n)
+	for i := range ballots {
+		ballots[i] = preferences
+	}
+	return ballots
+}
+
+func ballotsOf(groups ...[][]string) [][]string {
+	var ballots [][]string
+	for _, group := range groups {
+		ballots = append(ballots, group...)
+	}
+	return ballots
+}
+
+func TestTabulateRankedChoice(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []string
+		ballots    [][]string
+		winner     string
+		tied       []string
+		// eliminated lists the candidates eliminated in each round, the last round included
+		eliminated [][]string
+		exhausted  []int
+		lastCounts map[string]int
+	}{
+		{
+			name:       "first round majority",
+			candidates: []string{"A", "B", "C"},
+			ballots:    ballotsOf(repeat(5, "A"), repeat(3, "B"), repeat(1, "C")),
+			winner:     "A",
+			eliminated: [][]string{{}},
+			exhausted:  []int{0},
+			lastCounts: map[string]int{"A": 5, "B": 3, "C": 1},
+		},
+		{
+			name:       "lowest candidate's ballots transfer",
+			candidates: []string{"A", "B", "C"},
+			ballots:    ballotsOf(repeat(4, "A"), repeat(3, "B", "C"), repeat(2, "C", "B")),
+			winner:     "B",
+			eliminated: [][]string{{"C"}, {}},
+			exhausted:  []int{0, 0},
+			lastCounts: map[string]int{"A": 4, "B": 5},
+		},
+		{
+			name:       "candidates level on fewest votes are eliminated together",
+			candidates: []string{"A", "B", "C", "D"},
+			ballots:    ballotsOf(repeat(4, "A"), repeat(3, "B"), repeat(1, "C", "B"), repeat(1, "D", "B")),
+			winner:     "B",
+			eliminated: [][]string{{"C", "D"}, {}},
+			exhausted:  []int{0, 0},
+			lastCounts: map[string]int{"A": 4, "B": 5},
+		},
+		{
+			name:       "majority is of continuing ballots once some are exhausted",
+			candidates: []string{"A", "B", "C"},
+			ballots:    ballotsOf(repeat(4, "A"), repeat(3, "B"), repeat(2, "C")),
+			winner:     "A",
+			eliminated: [][]string{{"C"}, {}},
+			exhausted:  []int{0, 2},
+			lastCounts: map[string]int{"A": 4, "B": 3},
+		},
+		{
+			name:       "last candidates level is a tie",
+			candidates: []string{"A", "B", "C"},
+			ballots:    ballotsOf(repeat(3, "A"), repeat(3, "B"), repeat(2, "C")),
+			tied:       []string{"A", "B"},
+			eliminated: [][]string{{"C"}, {}},
+			exhausted:  []int{0, 2},
+			lastCounts: map[string]int{"A": 3, "B": 3},
+		},
+		{
+			name:       "first round tie",
+			candidates: []string{"B", "A"},
+			ballots:    ballotsOf(repeat(2, "A", "B"), repeat(2, "B", "A")),
+			tied:       []string{"A", "B"},
+			eliminated: [][]string{{}},
+			exhausted:  []int{0},
+			lastCounts: map[string]int{"A": 2, "B": 2},
+		},
+		{
+			name:       "no ballots",
+			candidates: []string{"A", "B"},
+			tied:       []string{"A", "B"},
+			eliminated: [][]string{{}},
+			exhausted:  []int{0},
+			lastCounts: map[string]int{"A": 0, "B": 0},
+		},
+		{
+			name:       "sole candidate wins without a majority",
+			candidates: []string{"A"},
+			ballots:    ballotsOf(repeat(1, "A"), repeat(3, "X")),
+			winner:     "A",
+			eliminated: [][]string{{}},
+			exhausted:  []int{3},
+			lastCounts: map[string]int{"A": 1},
+		},
+		{
+			name:       "preferences for candidates not running are skipped",
+			candidates: []string{"A", "B"},
+			ballots:    ballotsOf(repeat(2, "X", "A"), repeat(1, "B")),
+			winner:     "A",
+			eliminated: [][]string{{}},
+			exhausted:  []int{0},
+			lastCounts: map[string]int{"A": 2, "B": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := TabulateRankedChoice("Governor", tt.candidates, tt.ballots)
+
+			if result.Winner != tt.winner {
+				t.Errorf("winner is %q, want %q", result.Winner, tt.winner)
+			}
+			if !reflect.DeepEqual(result.Tied, tt.tied) {
+				t.Errorf("tied is %v, want %v", result.Tied, tt.tied)
+			}
+			if len(result.Rounds) != len(tt.eliminated) {
+				t.Fatalf("%d rounds, want %d: %+v", len(result.Rounds), len(tt.eliminated), result.Rounds)
+			}
+			for i, round := range result.Rounds {
+				if round.Round != i+1 {
+					t.Errorf("round %d is numbered %d", i+1, round.Round)
+				}
+				if !reflect.DeepEqual(round.Eliminated, tt.eliminated[i]) {
+					t.Errorf("round %d eliminated %v, want %v", i+1, round.Eliminated, tt.eliminated[i])
+				}
+				if round.Exhausted != tt.exhausted[i] {
+					t.Errorf("round %d has %d exhausted ballots, want %d", i+1, round.Exhausted, tt.exhausted[i])
+				}
+			}
+
+			last := result.Rounds[len(result.Rounds)-1]
+			if !reflect.DeepEqual(last.Counts, tt.lastCounts) {
+				t.Errorf("last round counts are %v, want %v", last.Counts, tt.lastCounts)
+			}
+			if last.Winner != tt.winner {
+				t.Errorf("last round winner is %q, want %q", last.Winner, tt.winner)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of recent tally events kept for clients that reconnect with a last event ID
+const tallyHistorySize = 1000
+
+// TallyEvent is a single vote count change pushed to live result subscribers.
+// Type "resync" tells the client its last event ID can no longer be caught up and it should refetch the results.
+type TallyEvent struct {
+	ID           int64  `json:"id"`
+	Type         string `json:"type"`
+	Position     string `json:"position,omitempty"`
+	CandidateID  int64  `json:"candidate_id,omitempty"`
+	PositionName string `json:"position_name,omitempty"`
+	Department   string `json:"department,omitempty"`
+	Delta        int    `json:"delta,omitempty"`
+	TotalVotes   int    `json:"total_votes,omitempty"`
+	Time         string `json:"time"`
+}
+
+// TallySubscription receives tally events for the positions it subscribed to
+type TallySubscription struct {
+	Events    chan TallyEvent
+	positions map[string]bool
+}
+
+func (s *TallySubscription) wants(event TallyEvent) bool {
+	return len(s.positions) == 0 || s.positions[event.Position]
+}
+
+var tallyHub = struct {
+	sync.Mutex
+	lastID      int64
+	history     []TallyEvent
+	subscribers map[*TallySubscription]bool
+}{subscribers: make(map[*TallySubscription]bool)}
+
+// SubscribeTally registers a subscriber for the given positions (all positions when empty).
+// Events newer than lastEventID are replayed into the subscription before live events.
+func SubscribeTally(positions []string, lastEventID int64) *TallySubscription {
+	sub := &TallySubscription{
+		Events:    make(chan TallyEvent, tallyHistorySize+16),
+		positions: make(map[string]bool),
+	}
+	for _, position := range positions {
+		if position != "" {
+			sub.positions[position] = true
+		}
+	}
+
+	tallyHub.Lock()
+	defer tallyHub.Unlock()
+
+	if lastEventID > 0 {
+		oldest := tallyHub.lastID - int64(len(tallyHub.history)) + 1
+		if lastEventID > tallyHub.lastID || lastEventID < oldest-1 {
+			sub.Events <- TallyEvent{ID: tallyHub.lastID, Type: "resync", Time: time.Now().Format(time.RFC3339)}
+		} else {
+			for _, event := range tallyHub.history {
+				if event.ID > lastEventID && sub.wants(event) {
+					sub.Events <- event
+				}
+			}
+		}
+	}
+
+	tallyHub.subscribers[sub] = true
+	return sub
+}
+
+// UnsubscribeTally removes a subscriber and closes its channel
+func UnsubscribeTally(sub *TallySubscription) {
+	tallyHub.Lock()
+	defer tallyHub.Unlock()
+
+	if tallyHub.subscribers[sub] {
+		delete(tallyHub.subscribers, sub)
+		close(sub.Events)
+	}
+}
+
+// PublishTally assigns IDs to the events, stores them for catch-up and fans them out.
+// Subscribers that cannot keep up are dropped and have to reconnect with their last event ID.
+func PublishTally(events []TallyEvent) {
+	tallyHub.Lock()
+	defer tallyHub.Unlock()
+
+	now := time.Now().Format(time.RFC3339)
+	for _, event := range events {
+		tallyHub.lastID++
+		event.ID = tallyHub.lastID
+		event.Type = "tally"
+		event.Time = now
+
+		tallyHub.history = append(tallyHub.history, event)
+		if len(tallyHub.history) > tallyHistorySize {
+			tallyHub.history = tallyHub.history[len(tallyHub.history)-tallyHistorySize:]
+		}
+
+		for sub := range tallyHub.subscribers {
+			if !sub.wants(event) {
+				continue
+			}
+			select {
+			case sub.Events <- event:
+			default:
+				delete(tallyHub.subscribers, sub)
+				close(sub.Events)
+			}
+		}
+	}
+}
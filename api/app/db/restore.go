@@ -0,0 +1,929 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+	"github.com/xuri/excelize/v2"
+)
+
+// ErrElectionOpen is returned when restoring a backup while voting is under way
+var ErrElectionOpen = errors.New("election is open")
+
+// Placeholders GenerateBackup writes for empty cells and for sheets whose data could not be read
+const (
+	emptyCell       = "[Empty]"
+	noDataAvailable = "No Data Available"
+)
+
+// Datetimes in the ElectionSettings sheet are written as MySQL returns them
+const backupDatetimeLayout = "2006-01-02 15:04:05"
+
+// Largest uncompressed workbook read when restoring, so a crafted file cannot exhaust memory
+const maxBackupUnzipBytes = 512 << 20
+
+// Most keys listed per change kind in a restore preview
+const maxDiffKeys = 50
+
+// backupSheets are the sheets a restore reads and the columns each must have. Turnout and RankedChoice
+// are derived from the others and ignored. Candidates' "ID", "Thumbnail URL" and "Withdrawn At" and
+// voters' "Fingerprint Hash" are read when present; backups made before they were exported lack them,
+// as they lack the Ballots and TieBreaks sheets.
+var backupSheets = []struct {
+	Name     string
+	Columns  []string
+	Optional bool
+}{
+	{Name: "ElectionSettings", Columns: []string{"Voting Start", "Voting End"}},
+	{Name: "Partylists", Columns: []string{"ID", "Name", "Acronym", "Logo URL", "Platform", "Color"}, Optional: true},
+	{Name: "Candidates", Columns: []string{"Position Name", "Name", "Position", "Year Level", "Program", "Partylist", "Credentials", "Photo URL"}},
+	{Name: "Votes", Columns: append([]string{"Position Name", "Name", "Position"}, voteCountColumns...)},
+	{Name: "Voters", Columns: []string{"Student ID", "Name", "Program", "Has Voted"}},
+	{Name: "Ballots", Columns: []string{"Ballot ID", "Kiosk ID", "Department", "Selections", "Cast At"}, Optional: true},
+	{Name: "TieBreaks", Columns: []string{"Position", "Winners", "Method", "Notes", "Resolved By", "Resolved At"}, Optional: true},
+}
+
+var voteCountColumns = []string{"COE Votes", "CBA Votes", "CICS Votes", "CIT Votes", "COED BSED Votes", "COED BEED Votes", "COED BPED Votes", "COED Total Votes", "Final Total Votes"}
+
+type backupRow struct {
+	number int
+	cells  []string
+}
+
+type backupSheet struct {
+	name    string
+	columns map[string]int
+	rows    []backupRow
+}
+
+// cell returns a row's value in the named column, or "" when the column or cell is absent
+func (s backupSheet) cell(row backupRow, column string) string {
+	i, ok := s.columns[column]
+	if !ok || i >= len(row.cells) {
+		return ""
+	}
+	value := strings.TrimSpace(row.cells[i])
+	if value == emptyCell {
+		return ""
+	}
+	return value
+}
+
+type backupCandidate struct {
+	ID           int64
+	PositionName string
+	Name         string
+	Position     string
+	YearLevel    string
+	Program      string
+	Partylist    string
+	Credentials  []models.Credential
+	PhotoURL     string
+	ThumbnailURL string
+	WithdrawnAt  string
+}
+
+type backupBallot struct {
+	BallotID   string
+	KioskID    string
+	Department string
+	Selections string
+	CastAt     string
+}
+
+type backupTieBreak struct {
+	Position   string
+	Winners    string
+	Method     string
+	Notes      string
+	ResolvedBy string
+	ResolvedAt string
+}
+
+// backupSnapshot is the election data held in a backup, or read from the database to compare with one
+type backupSnapshot struct {
+	settings      []models.ExcelElectionSetting
+	partylists    []models.Partylist
+	hasPartylists bool
+	hasIDs        bool
+	candidates    []backupCandidate
+	votes         []models.ExcelVotes
+	voters        []models.ExcelVoters
+	hasBallots    bool
+	ballots       []backupBallot
+	tieBreaks     []backupTieBreak
+}
+
+// MaxRestoreBytes is the largest backup file accepted for a restore
+func MaxRestoreBytes() int64 {
+	if revel.Config != nil {
+		return int64(revel.Config.IntDefault("backup.restore.max.bytes", 50<<20))
+	}
+	return 50 << 20
+}
+
+func restoreIssue(sheet string, row int, format string, args ...interface{}) models.RestoreIssue {
+	return models.RestoreIssue{Sheet: sheet, Row: row, Error: fmt.Sprintf(format, args...)}
+}
+
+// ReadBackupSheets reads the rows of every sheet in a workbook written by GenerateBackup
+func ReadBackupSheets(r io.Reader) (map[string][][]string, error) {
+	f, err := excelize.OpenReader(r, excelize.Options{UnzipSizeLimit: maxBackupUnzipBytes})
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sheets := map[string][][]string{}
+	for _, name := range f.GetSheetList() {
+		rows, err := f.GetRows(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sheet %s: %w", name, err)
+		}
+		sheets[name] = rows
+	}
+	return sheets, nil
+}
+
+// parseBackup reads the restorable sheets into a snapshot. Rows that fail validation are reported as
+// issues and left out, so the snapshot only holds what could be parsed.
+func parseBackup(sheets map[string][][]string) (*backupSnapshot, []models.RestoreIssue) {
+	var issues []models.RestoreIssue
+	tables := map[string]backupSheet{}
+
+	for _, spec := range backupSheets {
+		rows, ok := sheets[spec.Name]
+		if !ok {
+			if !spec.Optional {
+				issues = append(issues, restoreIssue(spec.Name, 0, "sheet is missing"))
+			}
+			continue
+		}
+		if len(rows) == 0 || (len(rows[0]) > 0 && rows[0][0] == noDataAvailable) {
+			issues = append(issues, restoreIssue(spec.Name, 0, "sheet has no data; it could not be read when the backup was made"))
+			continue
+		}
+
+		sheet := backupSheet{name: spec.Name, columns: map[string]int{}}
+		for i, header := range rows[0] {
+			sheet.columns[strings.TrimSpace(header)] = i
+		}
+		missing := false
+		for _, column := range spec.Columns {
+			if _, ok := sheet.columns[column]; !ok {
+				issues = append(issues, restoreIssue(spec.Name, 1, "column %q is missing", column))
+				missing = true
+			}
+		}
+		if missing {
+			continue
+		}
+
+		for i, cells := range rows[1:] {
+			if strings.TrimSpace(strings.Join(cells, "")) == "" {
+				continue
+			}
+			sheet.rows = append(sheet.rows, backupRow{number: i + 2, cells: cells})
+		}
+		tables[spec.Name] = sheet
+	}
+
+	snapshot := &backupSnapshot{}
+	if sheet, ok := tables["ElectionSettings"]; ok {
+		issues = append(issues, snapshot.readSettings(sheet)...)
+	}
+	if sheet, ok := tables["Partylists"]; ok {
+		snapshot.hasPartylists = true
+		issues = append(issues, snapshot.readPartylists(sheet)...)
+	}
+	if sheet, ok := tables["Candidates"]; ok {
+		issues = append(issues, snapshot.readCandidates(sheet)...)
+	}
+	if sheet, ok := tables["Votes"]; ok {
+		_, hasCandidates := tables["Candidates"]
+		issues = append(issues, snapshot.readVotes(sheet, hasCandidates)...)
+	}
+	if sheet, ok := tables["Voters"]; ok {
+		issues = append(issues, snapshot.readVoters(sheet)...)
+	}
+	if sheet, ok := tables["Ballots"]; ok {
+		snapshot.hasBallots = true
+		issues = append(issues, snapshot.readBallots(sheet)...)
+	}
+	if sheet, ok := tables["TieBreaks"]; ok {
+		issues = append(issues, snapshot.readTieBreaks(sheet)...)
+	}
+
+	return snapshot, issues
+}
+
+func (s *backupSnapshot) readSettings(sheet backupSheet) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	if len(sheet.rows) > 1 {
+		issues = append(issues, restoreIssue(sheet.name, 0, "sheet has %d rows; an election has one voting timeframe", len(sheet.rows)))
+	}
+
+	for _, row := range sheet.rows {
+		start, startErr := time.ParseInLocation(backupDatetimeLayout, sheet.cell(row, "Voting Start"), time.Local)
+		end, endErr := time.ParseInLocation(backupDatetimeLayout, sheet.cell(row, "Voting End"), time.Local)
+		switch {
+		case startErr != nil || endErr != nil:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "voting start and end must be datetimes such as 2025-03-01 08:00:00"))
+		case !end.After(start):
+			issues = append(issues, restoreIssue(sheet.name, row.number, "voting end must be after voting start"))
+		default:
+			s.settings = append(s.settings, models.ExcelElectionSetting{
+				VotingStart: start.Format(backupDatetimeLayout),
+				VotingEnd:   end.Format(backupDatetimeLayout),
+			})
+		}
+	}
+	return issues
+}
+
+func (s *backupSnapshot) readPartylists(sheet backupSheet) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	ids := map[int64]bool{}
+	names := map[string]bool{}
+
+	for _, row := range sheet.rows {
+		partylist := models.Partylist{
+			Name:     sheet.cell(row, "Name"),
+			Acronym:  sheet.cell(row, "Acronym"),
+			LogoURL:  sheet.cell(row, "Logo URL"),
+			Platform: sheet.cell(row, "Platform"),
+			Color:    sheet.cell(row, "Color"),
+		}
+		id, err := strconv.ParseInt(sheet.cell(row, "ID"), 10, 64)
+		switch {
+		case err != nil || id <= 0:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "ID must be a positive number"))
+			continue
+		case ids[id]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "partylist ID %d appears more than once", id))
+			continue
+		case names[strings.ToLower(partylist.Name)]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "partylist %s appears more than once", partylist.Name))
+			continue
+		}
+		if err := ValidatePartylist(partylist); err != nil {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "%v", err))
+			continue
+		}
+
+		partylist.ID = id
+		ids[id] = true
+		names[strings.ToLower(partylist.Name)] = true
+		s.partylists = append(s.partylists, partylist)
+	}
+	return issues
+}
+
+func (s *backupSnapshot) readCandidates(sheet backupSheet) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	_, s.hasIDs = sheet.columns["ID"]
+	ids := map[int64]bool{}
+	positionNames := map[string]bool{}
+
+	for _, row := range sheet.rows {
+		candidate := backupCandidate{
+			PositionName: sheet.cell(row, "Position Name"),
+			Name:         sheet.cell(row, "Name"),
+			Position:     sheet.cell(row, "Position"),
+			YearLevel:    sheet.cell(row, "Year Level"),
+			Program:      sheet.cell(row, "Program"),
+			Partylist:    sheet.cell(row, "Partylist"),
+			PhotoURL:     sheet.cell(row, "Photo URL"),
+			ThumbnailURL: sheet.cell(row, "Thumbnail URL"),
+			WithdrawnAt:  sheet.cell(row, "Withdrawn At"),
+		}
+
+		if s.hasIDs {
+			id, err := strconv.ParseInt(sheet.cell(row, "ID"), 10, 64)
+			if err != nil || id <= 0 {
+				issues = append(issues, restoreIssue(sheet.name, row.number, "ID must be a positive number"))
+				continue
+			}
+			if ids[id] {
+				issues = append(issues, restoreIssue(sheet.name, row.number, "candidate ID %d appears more than once", id))
+				continue
+			}
+			candidate.ID = id
+		}
+
+		switch {
+		case candidate.PositionName == "" || candidate.Name == "" || candidate.Position == "":
+			issues = append(issues, restoreIssue(sheet.name, row.number, "position name, name and position are required"))
+			continue
+		case positionNames[candidate.PositionName]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "%s appears more than once", candidate.PositionName))
+			continue
+		}
+		if candidate.WithdrawnAt != "" {
+			if _, err := time.ParseInLocation(backupDatetimeLayout, candidate.WithdrawnAt, time.Local); err != nil {
+				issues = append(issues, restoreIssue(sheet.name, row.number, "withdrawn at must be a datetime such as 2025-03-01 08:00:00"))
+				continue
+			}
+		}
+
+		credentials, err := parseBackupCredentials(sheet.cell(row, "Credentials"))
+		if err != nil {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "credentials are not valid: %v", err))
+			continue
+		}
+		candidate.Credentials = credentials
+
+		ids[candidate.ID] = true
+		positionNames[candidate.PositionName] = true
+		s.candidates = append(s.candidates, candidate)
+	}
+	return issues
+}
+
+// parseBackupCredentials reads a Credentials cell. Backups made before credentials were structured
+// joined their titles with " | ".
+func parseBackupCredentials(value string) ([]models.Credential, error) {
+	if strings.HasPrefix(value, "[") {
+		return ParseCredentials(value)
+	}
+
+	credentials := []models.Credential{}
+	for _, title := range strings.Split(value, " | ") {
+		if title = strings.TrimSpace(title); title != "" {
+			credentials = append(credentials, models.Credential{Type: "other", Title: title})
+		}
+	}
+	return credentials, nil
+}
+
+// readVotes reads the vote counters, checking each against its candidate and its own totals. Every
+// candidate must have exactly one counter row.
+func (s *backupSnapshot) readVotes(sheet backupSheet, checkCandidates bool) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	candidates := map[string]backupCandidate{}
+	for _, candidate := range s.candidates {
+		candidates[candidate.PositionName] = candidate
+	}
+	seen := map[string]bool{}
+
+	for _, row := range sheet.rows {
+		positionName := sheet.cell(row, "Position Name")
+		if seen[positionName] {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "%s appears more than once", positionName))
+			continue
+		}
+		seen[positionName] = true
+
+		candidate, ok := candidates[positionName]
+		if checkCandidates && !ok {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "%s is not in the Candidates sheet", positionName))
+			continue
+		}
+		if ok && (sheet.cell(row, "Name") != candidate.Name || sheet.cell(row, "Position") != candidate.Position) {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "name and position do not match candidate %s", positionName))
+			continue
+		}
+
+		counts := make([]int, len(voteCountColumns))
+		valid := true
+		for i, column := range voteCountColumns {
+			count, err := strconv.Atoi(sheet.cell(row, column))
+			if err != nil || count < 0 {
+				issues = append(issues, restoreIssue(sheet.name, row.number, "%s must be a whole number of at least 0", column))
+				valid = false
+				break
+			}
+			counts[i] = count
+		}
+		if !valid {
+			continue
+		}
+
+		votes := models.ExcelVotes{
+			PositionName: positionName,
+			Name:         sheet.cell(row, "Name"),
+			Position:     sheet.cell(row, "Position"),
+			COEVotes:     counts[0],
+			CBAVotes:     counts[1],
+			CICSVotes:    counts[2],
+			CITVotes:     counts[3],
+			COEDBSED:     counts[4],
+			COEDBEED:     counts[5],
+			COEDBPED:     counts[6],
+			COEDVotes:    counts[7],
+			TotalVotes:   counts[8],
+		}
+		if votes.COEDVotes != votes.COEDBSED+votes.COEDBEED+votes.COEDBPED {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "COED total does not equal the sum of the COED programs"))
+			continue
+		}
+		if votes.TotalVotes != votes.COEVotes+votes.CBAVotes+votes.CICSVotes+votes.CITVotes+votes.COEDVotes {
+			issues = append(issues, restoreIssue(sheet.name, row.number, "final total does not equal the sum of the departments"))
+			continue
+		}
+		s.votes = append(s.votes, votes)
+	}
+
+	if checkCandidates {
+		for _, candidate := range s.candidates {
+			if !seen[candidate.PositionName] {
+				issues = append(issues, restoreIssue(sheet.name, 0, "candidate %s has no row in the Votes sheet", candidate.PositionName))
+			}
+		}
+	}
+	return issues
+}
+
+func (s *backupSnapshot) readVoters(sheet backupSheet) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	seen := map[string]bool{}
+
+	for _, row := range sheet.rows {
+		voter := models.ExcelVoters{
+			StudentID:       sheet.cell(row, "Student ID"),
+			StudentName:     sheet.cell(row, "Name"),
+			Program:         sheet.cell(row, "Program"),
+			FingerprintHash: sheet.cell(row, "Fingerprint Hash"),
+		}
+		hasVoted, err := strconv.ParseBool(sheet.cell(row, "Has Voted"))
+
+		switch {
+		case voter.StudentID == "" || voter.StudentName == "" || voter.Program == "":
+			issues = append(issues, restoreIssue(sheet.name, row.number, "student ID, name and program are required"))
+		case seen[voter.StudentID]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "student %s appears more than once", voter.StudentID))
+		case err != nil:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "has voted must be 0 or 1"))
+		case len(voter.FingerprintHash) > 64:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "fingerprint hash is longer than 64 characters"))
+		default:
+			voter.HasVoted = hasVoted
+			seen[voter.StudentID] = true
+			s.voters = append(s.voters, voter)
+		}
+	}
+	return issues
+}
+
+func (s *backupSnapshot) readBallots(sheet backupSheet) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	departments := map[string]bool{}
+	for _, department := range departmentColumns {
+		departments[department.Column] = true
+	}
+	seen := map[string]bool{}
+
+	for _, row := range sheet.rows {
+		ballot := backupBallot{
+			BallotID:   sheet.cell(row, "Ballot ID"),
+			KioskID:    sheet.cell(row, "Kiosk ID"),
+			Department: sheet.cell(row, "Department"),
+			Selections: sheet.cell(row, "Selections"),
+			CastAt:     sheet.cell(row, "Cast At"),
+		}
+		_, castErr := time.ParseInLocation(backupDatetimeLayout, ballot.CastAt, time.Local)
+
+		switch {
+		case len(ballot.BallotID) != 36:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "ballot ID must be a UUID"))
+		case seen[ballot.BallotID]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "ballot %s appears more than once", ballot.BallotID))
+		case ballot.KioskID == "" || len(ballot.KioskID) > 64:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "kiosk ID is required and at most 64 characters"))
+		case !departments[ballot.Department]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "department %q is not a known department", ballot.Department))
+		case !json.Valid([]byte(ballot.Selections)):
+			issues = append(issues, restoreIssue(sheet.name, row.number, "selections are not valid JSON"))
+		case castErr != nil:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "cast at must be a datetime such as 2025-03-01 08:00:00"))
+		default:
+			seen[ballot.BallotID] = true
+			s.ballots = append(s.ballots, ballot)
+		}
+	}
+	return issues
+}
+
+func (s *backupSnapshot) readTieBreaks(sheet backupSheet) []models.RestoreIssue {
+	var issues []models.RestoreIssue
+	seen := map[string]bool{}
+
+	for _, row := range sheet.rows {
+		tieBreak := backupTieBreak{
+			Position:   sheet.cell(row, "Position"),
+			Winners:    sheet.cell(row, "Winners"),
+			Method:     sheet.cell(row, "Method"),
+			Notes:      sheet.cell(row, "Notes"),
+			ResolvedBy: sheet.cell(row, "Resolved By"),
+			ResolvedAt: sheet.cell(row, "Resolved At"),
+		}
+		var winners []string
+		winnersErr := json.Unmarshal([]byte(tieBreak.Winners), &winners)
+		_, resolvedErr := time.ParseInLocation(backupDatetimeLayout, tieBreak.ResolvedAt, time.Local)
+
+		switch {
+		case tieBreak.Position == "" || tieBreak.Method == "" || tieBreak.ResolvedBy == "":
+			issues = append(issues, restoreIssue(sheet.name, row.number, "position, method and resolved by are required"))
+		case seen[tieBreak.Position]:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "%s appears more than once", tieBreak.Position))
+		case winnersErr != nil || len(winners) == 0:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "winners must be a JSON list of position names"))
+		case resolvedErr != nil:
+			issues = append(issues, restoreIssue(sheet.name, row.number, "resolved at must be a datetime such as 2025-03-01 08:00:00"))
+		default:
+			seen[tieBreak.Position] = true
+			s.tieBreaks = append(s.tieBreaks, tieBreak)
+		}
+	}
+	return issues
+}
+
+// currentSnapshot reads the database through the same functions and parser as a backup, so the two
+// compare like for like
+func currentSnapshot() (*backupSnapshot, error) {
+	sheets := map[string][][]string{
+		"ElectionSettings": GetElectionSettingsData(),
+		"Partylists":       GetPartylistsData(),
+		"Candidates":       GetCandidatesData(),
+		"Votes":            GetVotesData(),
+		"Voters":           GetVotersData(),
+		"Ballots":          GetBallotsData(),
+		"TieBreaks":        GetTieBreaksData(),
+	}
+	for name, rows := range sheets {
+		if rows == nil {
+			return nil, fmt.Errorf("failed to read %s from the database", name)
+		}
+	}
+
+	snapshot, issues := parseBackup(sheets)
+	for _, issue := range issues {
+		revel.AppLog.Warnf("Current %s data row %d does not pass backup validation: %s", issue.Sheet, issue.Row, issue.Error)
+	}
+	return snapshot, nil
+}
+
+// loadRestore parses a backup and compares it with the database. Voters missing a fingerprint hash
+// keep the one already stored for their student ID.
+func loadRestore(sheets map[string][][]string) (*backupSnapshot, *backupSnapshot, []models.RestoreIssue, error) {
+	backup, issues := parseBackup(sheets)
+	if len(issues) > 0 {
+		return nil, nil, issues, nil
+	}
+
+	current, err := currentSnapshot()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	fingerprints := map[string]string{}
+	for _, voter := range current.voters {
+		fingerprints[voter.StudentID] = voter.FingerprintHash
+	}
+	for i, voter := range backup.voters {
+		if voter.FingerprintHash != "" {
+			continue
+		}
+		if fingerprint, ok := fingerprints[voter.StudentID]; ok && fingerprint != "" {
+			backup.voters[i].FingerprintHash = fingerprint
+			continue
+		}
+		issues = append(issues, restoreIssue("Voters", 0,
+			"student %s has no fingerprint hash in the backup and is not registered in the database", voter.StudentID))
+	}
+	if len(issues) > 0 {
+		return nil, nil, issues, nil
+	}
+
+	return backup, current, nil, nil
+}
+
+type keyedTable struct {
+	name string
+	rows map[string]string
+}
+
+// keyed lists each table's rows by key, with a rendering of the row used to detect changes. Database
+// IDs are left out; restored rows keep the backup's IDs whenever it has them.
+func (s *backupSnapshot) keyed() []keyedTable {
+	settings := map[string]string{}
+	for _, setting := range s.settings {
+		settings["voting timeframe"] = fmt.Sprintf("%s to %s", setting.VotingStart, setting.VotingEnd)
+	}
+
+	partylists := map[string]string{}
+	for _, partylist := range s.partylists {
+		partylist.ID = 0
+		partylists[partylist.Name] = fmt.Sprintf("%v", partylist)
+	}
+
+	candidates := map[string]string{}
+	for _, candidate := range s.candidates {
+		candidate.ID = 0
+		candidates[candidate.PositionName] = fmt.Sprintf("%v", candidate)
+	}
+
+	votes := map[string]string{}
+	for _, row := range s.votes {
+		votes[row.PositionName] = fmt.Sprintf("%v", row)
+	}
+
+	voters := map[string]string{}
+	for _, voter := range s.voters {
+		voters[voter.StudentID] = fmt.Sprintf("%v", voter)
+	}
+
+	ballots := map[string]string{}
+	for _, ballot := range s.ballots {
+		ballots[ballot.BallotID] = fmt.Sprintf("%v", ballot)
+	}
+
+	tieBreaks := map[string]string{}
+	for _, tieBreak := range s.tieBreaks {
+		tieBreaks[tieBreak.Position] = fmt.Sprintf("%v", tieBreak)
+	}
+
+	return []keyedTable{
+		{"election_settings", settings},
+		{"partylists", partylists},
+		{"candidates", candidates},
+		{"votes", votes},
+		{"voters", voters},
+		{"ballots", ballots},
+		{"tie_breaks", tieBreaks},
+	}
+}
+
+func diffTable(table string, current, backup map[string]string) models.RestoreTableDiff {
+	diff := models.RestoreTableDiff{
+		Table:   table,
+		Current: len(current),
+		Backup:  len(backup),
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []string{},
+	}
+
+	keys := make([]string, 0, len(backup))
+	for key := range backup {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		existing, ok := current[key]
+		switch {
+		case !ok:
+			diff.AddedCount++
+			if len(diff.Added) < maxDiffKeys {
+				diff.Added = append(diff.Added, key)
+			}
+		case existing != backup[key]:
+			diff.ChangedCount++
+			if len(diff.Changed) < maxDiffKeys {
+				diff.Changed = append(diff.Changed, key)
+			}
+		}
+	}
+
+	keys = keys[:0]
+	for key := range current {
+		if _, ok := backup[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	diff.RemovedCount = len(keys)
+	if len(keys) > maxDiffKeys {
+		keys = keys[:maxDiffKeys]
+	}
+	diff.Removed = append(diff.Removed, keys...)
+
+	return diff
+}
+
+// restoreWarnings describes what a restore loses or cannot bring back
+func restoreWarnings(backup *backupSnapshot) []string {
+	warnings := []string{}
+
+	var ballots, tieBreaks int
+	if err := DB.QueryRow(`SELECT (SELECT COUNT(*) FROM ballots), (SELECT COUNT(*) FROM tie_breaks)`).Scan(&ballots, &tieBreaks); err != nil {
+		revel.AppLog.Errorf("Failed to count ballots: %v", err)
+	}
+	// Backups made before ballots and tie resolutions were exported cannot bring them back
+	if !backup.hasBallots {
+		if ballots > 0 {
+			warnings = append(warnings, fmt.Sprintf("the backup predates ballot records; %d ballots will be deleted and ranked-choice positions cannot be recounted after the restore", ballots))
+		}
+		if tieBreaks > 0 {
+			warnings = append(warnings, fmt.Sprintf("the backup predates tie resolution records; %d tie resolutions will be deleted", tieBreaks))
+		}
+	}
+
+	if !backup.hasPartylists {
+		warnings = append(warnings, "the backup has no Partylists sheet; partylists will be recreated from candidate partylist names without acronyms, colors or platforms")
+	}
+	if !backup.hasIDs && len(backup.candidates) > 0 {
+		warnings = append(warnings, "the backup predates candidate IDs; restored candidates will get new IDs")
+	}
+
+	for _, setting := range backup.settings {
+		now := time.Now().Format(backupDatetimeLayout)
+		if setting.VotingStart <= now && now <= setting.VotingEnd {
+			warnings = append(warnings, "the backup's voting timeframe includes the current time, so voting will reopen after the restore")
+		}
+	}
+
+	stored := map[string]bool{}
+	photos, err := Photos.List()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to list photos: %v", err)
+	}
+	for _, photo := range photos {
+		stored[photo.Key] = true
+	}
+	for _, candidate := range backup.candidates {
+		if candidate.PhotoURL != "" && err == nil && !stored[path.Base(candidate.PhotoURL)] {
+			warnings = append(warnings, fmt.Sprintf("the photo of %s (%s) is not in photo storage", candidate.PositionName, path.Base(candidate.PhotoURL)))
+		}
+		if candidate.ThumbnailURL != "" && err == nil && !stored[path.Base(candidate.ThumbnailURL)] {
+			warnings = append(warnings, fmt.Sprintf("the photo thumbnail of %s (%s) is not in photo storage", candidate.PositionName, path.Base(candidate.ThumbnailURL)))
+		}
+	}
+
+	return warnings
+}
+
+// PreviewRestore validates a backup and lists how restoring it would change the database, without
+// changing anything
+func PreviewRestore(filename string, sheets map[string][][]string) (models.RestorePreview, []models.RestoreIssue, error) {
+	preview := models.RestorePreview{Filename: filename}
+
+	backup, current, issues, err := loadRestore(sheets)
+	if err != nil || len(issues) > 0 {
+		return preview, issues, err
+	}
+
+	currentTables := current.keyed()
+	for i, table := range backup.keyed() {
+		preview.Tables = append(preview.Tables, diffTable(table.name, currentTables[i].rows, table.rows))
+	}
+	preview.Warnings = restoreWarnings(backup)
+
+	return preview, nil, nil
+}
+
+// RestoreBackup replaces the election data with a backup's in one transaction, and returns the number
+// of rows restored per table. Kiosks, positions, admins and the audit log are kept. Ballots and tie
+// resolutions are replaced with the backup's; backups made before they were exported clear them.
+func RestoreBackup(filename string, sheets map[string][][]string, username string) (map[string]int, []models.RestoreIssue, error) {
+	if ElectionOpen() {
+		return nil, nil, ErrElectionOpen
+	}
+
+	backup, _, issues, err := loadRestore(sheets)
+	if err != nil || len(issues) > 0 {
+		return nil, issues, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// DELETE rather than TRUNCATE, which commits implicitly and could not be rolled back
+	for _, table := range []string{"tie_breaks", "ballots", "votes", "candidates", "partylists", "voters", "election_settings"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	for _, partylist := range backup.partylists {
+		_, err := tx.Exec(`INSERT INTO partylists (id, name, acronym, logo_url, platform, color) VALUES (?, ?, ?, ?, ?, ?)`,
+			partylist.ID, partylist.Name, partylist.Acronym, partylist.LogoURL, partylist.Platform, partylist.Color)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore partylist %s: %w", partylist.Name, err)
+		}
+	}
+
+	candidateIDs := map[string]int64{}
+	for _, candidate := range backup.candidates {
+		// Backups made before partylists were stored name them only on their candidates
+		partylistID, partylist, err := ResolvePartylist(tx, nil, candidate.Partylist)
+		if errors.Is(err, ErrUnknownPartylist) {
+			var id int64
+			id, err = SavePartylist(tx, models.Partylist{Name: candidate.Partylist})
+			partylistID, partylist = &id, strings.TrimSpace(candidate.Partylist)
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore partylist of %s: %w", candidate.PositionName, err)
+		}
+
+		credentialsJSON, err := json.Marshal(candidate.Credentials)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+
+		// A NULL id lets backups made before candidate IDs take new ones
+		var id interface{}
+		if candidate.ID > 0 {
+			id = candidate.ID
+		}
+		var withdrawnAt interface{}
+		if candidate.WithdrawnAt != "" {
+			withdrawnAt = candidate.WithdrawnAt
+		}
+		result, err := tx.Exec(`INSERT INTO candidates (id, position_name, name, position, year_level, program, partylist, partylist_id, credentials,
+			photo_url, thumbnail_url, withdrawn_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, candidate.PositionName, candidate.Name, candidate.Position, candidate.YearLevel, candidate.Program,
+			partylist, partylistID, string(credentialsJSON), candidate.PhotoURL, candidate.ThumbnailURL, withdrawnAt)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore candidate %s: %w", candidate.PositionName, err)
+		}
+		candidateIDs[candidate.PositionName], _ = result.LastInsertId()
+	}
+
+	for _, row := range backup.votes {
+		_, err := tx.Exec(`INSERT INTO votes (position_name, candidate_id, name, position, coe_votes, cba_votes, cics_votes, cit_votes, coed_bsed, coed_beed, coed_bped)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			row.PositionName, candidateIDs[row.PositionName], row.Name, row.Position,
+			row.COEVotes, row.CBAVotes, row.CICSVotes, row.CITVotes, row.COEDBSED, row.COEDBEED, row.COEDBPED)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore votes for %s: %w", row.PositionName, err)
+		}
+	}
+
+	for _, voter := range backup.voters {
+		_, err := tx.Exec(`INSERT INTO voters (fingerprint_hash, student_name, student_id, program, has_voted) VALUES (?, ?, ?, ?, ?)`,
+			voter.FingerprintHash, voter.StudentName, voter.StudentID, voter.Program, voter.HasVoted)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore voter %s: %w", voter.StudentID, err)
+		}
+	}
+
+	for _, ballot := range backup.ballots {
+		_, err := tx.Exec(`INSERT INTO ballots (ballot_id, kiosk_id, department, selections, cast_at) VALUES (?, ?, ?, ?, ?)`,
+			ballot.BallotID, ballot.KioskID, ballot.Department, ballot.Selections, ballot.CastAt)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore ballot %s: %w", ballot.BallotID, err)
+		}
+	}
+
+	for _, tieBreak := range backup.tieBreaks {
+		_, err := tx.Exec(`INSERT INTO tie_breaks (position, winners, method, notes, resolved_by, resolved_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			tieBreak.Position, tieBreak.Winners, tieBreak.Method, tieBreak.Notes, tieBreak.ResolvedBy, tieBreak.ResolvedAt)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore tie resolution for %s: %w", tieBreak.Position, err)
+		}
+	}
+
+	// The restored election starts inactive; it is only open again if its timeframe includes now
+	for _, setting := range backup.settings {
+		_, err := tx.Exec(`INSERT INTO election_settings (id, voting_start, voting_end, is_active) VALUES (1, ?, ?, 0)`,
+			setting.VotingStart, setting.VotingEnd)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("failed to restore election settings: %w", err)
+		}
+	}
+
+	restored := map[string]int{
+		"election_settings": len(backup.settings),
+		"partylists":        len(backup.partylists),
+		"candidates":        len(backup.candidates),
+		"votes":             len(backup.votes),
+		"voters":            len(backup.voters),
+		"ballots":           len(backup.ballots),
+		"tie_breaks":        len(backup.tieBreaks),
+	}
+	if err := RecordAudit(tx, username, "restore_backup", filename, restored); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	InvalidateResults()
+	invalidateBallotCache()
+	return restored, nil, nil
+}
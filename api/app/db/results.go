@@ -0,0 +1,218 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// Department vote columns as stored in the votes table, with the board member position each one elects
+var departmentColumns = []struct {
+	Key         string
+	Column      string
+	BoardMember string
+}{
+	{"coe", "coe_votes", "BM (COE)"},
+	{"cba", "cba_votes", "BM (CBA)"},
+	{"cics", "cics_votes", "BM (BSIT)"},
+	{"cit", "cit_votes", "BM (BIT)"},
+	{"coed_bsed", "coed_bsed", "BM (BSED)"},
+	{"coed_beed", "coed_beed", "BM (BEED)"},
+	{"coed_bped", "coed_bped", "BM (BPED-BTLED-BTVDED)"},
+}
+
+var resultsCache = struct {
+	sync.Mutex
+	results   models.Results
+	etag      string
+	expiresAt time.Time
+}{}
+
+// InvalidateResults drops the cached results so the next request recomputes them
+func InvalidateResults() {
+	resultsCache.Lock()
+	resultsCache.expiresAt = time.Time{}
+	resultsCache.Unlock()
+}
+
+func resultsCacheTTL() time.Duration {
+	ttl := 5 * time.Second
+	if revel.Config != nil {
+		if value, ok := revel.Config.String("results.cache.ttl"); ok {
+			if parsed, err := time.ParseDuration(value); err == nil {
+				ttl = parsed
+			}
+		}
+	}
+	return ttl
+}
+
+// Percentage returns part as a percentage of whole rounded to two decimals
+func Percentage(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*10000) / 100
+}
+
+// GetResults returns the full results tree and its ETag, served from a short-lived cache
+func GetResults() (models.Results, string, error) {
+	resultsCache.Lock()
+	defer resultsCache.Unlock()
+
+	if time.Now().Before(resultsCache.expiresAt) {
+		return resultsCache.results, resultsCache.etag, nil
+	}
+
+	results, err := computeResults()
+	if err != nil {
+		return models.Results{}, "", err
+	}
+
+	// GeneratedAt is left out of the ETag so unchanged counts keep the same tag
+	generatedAt := results.GeneratedAt
+	results.GeneratedAt = ""
+	body, err := json.Marshal(results)
+	if err != nil {
+		return models.Results{}, "", err
+	}
+	results.GeneratedAt = generatedAt
+	sum := sha256.Sum256(body)
+
+	resultsCache.results = results
+	resultsCache.etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	resultsCache.expiresAt = time.Now().Add(resultsCacheTTL())
+
+	return resultsCache.results, resultsCache.etag, nil
+}
+
+func computeResults() (models.Results, error) {
+	results := models.Results{Positions: []models.ResultsPosition{}}
+
+	// Ballots per department decide how many voters could vote for each board member position
+	ballotsByDepartment := make(map[string]int)
+	rows, err := DB.Query(`SELECT department, COUNT(*) FROM ballots GROUP BY department`)
+	if err != nil {
+		return results, err
+	}
+	for rows.Next() {
+		var department string
+		var count int
+		if err := rows.Scan(&department, &count); err != nil {
+			rows.Close()
+			return results, err
+		}
+		ballotsByDepartment[department] = count
+		results.BallotsCast += count
+	}
+	rows.Close()
+
+	err = DB.QueryRow(`SELECT COUNT(*), COALESCE(SUM(has_voted), 0) FROM voters`).Scan(&results.Turnout.Registered, &results.Turnout.Voted)
+	if err != nil {
+		return results, err
+	}
+	results.Turnout.Percentage = Percentage(results.Turnout.Voted, results.Turnout.Registered)
+
+	rules, err := positionRules()
+	if err != nil {
+		return results, err
+	}
+
+	rows, err = DB.Query(`SELECT COALESCE(candidate_id, 0), position_name, name, position, coe_votes, cba_votes, cics_votes, cit_votes, coed_bsed, coed_beed, coed_bped, coed_votes, total_votes FROM votes ORDER BY position, name`)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	positionIndex := make(map[string]int)
+	for rows.Next() {
+		var v models.ExcelVotes
+		var candidateID int64
+		err := rows.Scan(&candidateID, &v.PositionName, &v.Name, &v.Position, &v.COEVotes, &v.CBAVotes, &v.CICSVotes, &v.CITVotes,
+			&v.COEDBSED, &v.COEDBEED, &v.COEDBPED, &v.COEDVotes, &v.TotalVotes)
+		if err != nil {
+			return results, err
+		}
+
+		index, ok := positionIndex[v.Position]
+		if !ok {
+			index = len(results.Positions)
+			positionIndex[v.Position] = index
+			rule := ruleFor(rules, v.Position)
+			results.Positions = append(results.Positions, models.ResultsPosition{
+				Position:        v.Position,
+				Seats:           rule.Seats,
+				MaxSelections:   rule.MaxSelections,
+				Tabulation:      rule.Tabulation,
+				Candidates:      []models.ResultsCandidate{},
+				EligibleBallots: eligibleBallots(v.Position, ballotsByDepartment, results.BallotsCast),
+			})
+		}
+
+		position := &results.Positions[index]
+		position.TotalVotes += v.TotalVotes
+		position.Candidates = append(position.Candidates, models.ResultsCandidate{
+			CandidateID:  candidateID,
+			PositionName: v.PositionName,
+			Name:         v.Name,
+			Departments: map[string]int{
+				"coe":       v.COEVotes,
+				"cba":       v.CBAVotes,
+				"cics":      v.CICSVotes,
+				"cit":       v.CITVotes,
+				"coed":      v.COEDVotes,
+				"coed_bsed": v.COEDBSED,
+				"coed_beed": v.COEDBEED,
+				"coed_bped": v.COEDBPED,
+			},
+			TotalVotes: v.TotalVotes,
+		})
+	}
+
+	rankedChoice, err := GetRankedChoiceResults()
+	if err != nil {
+		return results, err
+	}
+	rounds := make(map[string][]models.RankedChoiceRound)
+	for _, result := range rankedChoice {
+		rounds[result.Position] = result.Rounds
+	}
+
+	for i := range results.Positions {
+		position := &results.Positions[i]
+		position.Rounds = rounds[position.Position]
+		for j := range position.Candidates {
+			position.Candidates[j].Percentage = Percentage(position.Candidates[j].TotalVotes, position.TotalVotes)
+		}
+		// On multi-selection positions every selection a ballot left unused counts as an abstention.
+		// Ranked-choice counters only hold first preferences, so a ballot abstains there by ranking no one.
+		selectionsPerBallot := position.MaxSelections
+		if position.Tabulation == TabulationRankedChoice {
+			selectionsPerBallot = 1
+		}
+		position.Abstentions = position.EligibleBallots*selectionsPerBallot - position.TotalVotes
+		if position.Abstentions < 0 {
+			position.Abstentions = 0
+		}
+	}
+
+	results.GeneratedAt = time.Now().Format(time.RFC3339)
+	return results, nil
+}
+
+// eligibleBallots counts the ballots that could select a candidate for the given position
+func eligibleBallots(position string, ballotsByDepartment map[string]int, ballotsCast int) int {
+	for _, department := range departmentColumns {
+		if department.BoardMember == position {
+			return ballotsByDepartment[department.Column]
+		}
+	}
+	return ballotsCast
+}
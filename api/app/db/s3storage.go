@@ -0,0 +1,340 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// S3Storage keeps objects in an S3-compatible bucket (AWS S3, MinIO, ...) using path-style requests
+// signed with AWS Signature Version 4. Objects of one store share a key prefix.
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	URLExpiry time.Duration
+	Client    *http.Client
+}
+
+// NewS3Storage configures a store from the storage.s3.* settings and the S3_ACCESS_KEY / S3_SECRET_KEY environment variables
+func NewS3Storage(prefix string) (*S3Storage, error) {
+	s := &S3Storage{
+		Region:    "us-east-1",
+		Prefix:    prefix,
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		URLExpiry: 15 * time.Minute,
+		Client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	if revel.Config != nil {
+		s.Endpoint = strings.TrimSuffix(revel.Config.StringDefault("storage.s3.endpoint", ""), "/")
+		s.Region = revel.Config.StringDefault("storage.s3.region", s.Region)
+		s.Bucket = revel.Config.StringDefault("storage.s3.bucket", "")
+		if value, ok := revel.Config.String("storage.s3.url.expiry"); ok {
+			if expiry, err := time.ParseDuration(value); err == nil {
+				s.URLExpiry = expiry
+			}
+		}
+	}
+
+	if s.Endpoint == "" || s.Bucket == "" {
+		return nil, fmt.Errorf("storage.s3.endpoint and storage.s3.bucket are required")
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("S3_ACCESS_KEY and S3_SECRET_KEY are required")
+	}
+	return s, nil
+}
+
+func (s *S3Storage) Put(key string, r io.Reader, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	// A seekable body, such as a backup's temporary file, is hashed for the signature and then sent
+	// as it is read, rather than held in memory
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := s.send(http.MethodPut, s.Prefix+key, nil, body, size, hex.EncodeToString(hash.Sum(nil)), map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s.check(resp, key)
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(http.MethodGet, s.Prefix+key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(resp, key); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodDelete, s.Prefix+key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s.check(resp, key)
+}
+
+func (s *S3Storage) List() ([]StoredObject, error) {
+	objects := []StoredObject{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = s.check(resp, "")
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			key := strings.TrimPrefix(content.Key, s.Prefix)
+			if validKey(key) == nil {
+				objects = append(objects, StoredObject{Key: key, Size: content.Size, ModTime: content.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// URL returns a presigned GET URL valid for storage.s3.url.expiry
+func (s *S3Storage) URL(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	path := s.objectPath(s.Prefix + key)
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKey + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {fmt.Sprintf("%d", int(s.URLExpiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		path,
+		canonicalQuery(query),
+		"host:" + endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := s.sign(now, amzDate, scope, canonicalRequest)
+
+	return s.Endpoint + path + "?" + canonicalQuery(query) + "&X-Amz-Signature=" + signature, nil
+}
+
+// do sends a request signed with an Authorization header
+func (s *S3Storage) do(method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	payloadHash := sha256.Sum256(body)
+	return s.send(method, key, query, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(payloadHash[:]), headers)
+}
+
+// send sends a request with a body of size bytes whose SHA-256 is payloadHash, signed with an
+// Authorization header
+func (s *S3Storage) send(method, key string, query url.Values, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.objectPath(key)
+	target := s.Endpoint + path
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+	// The body is not closed by the request; whoever passed it in closes it
+	req, err := http.NewRequest(method, target, io.NopCloser(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + endpoint.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery(query),
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	signature := s.sign(now, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+
+	return s.Client.Do(req)
+}
+
+func (s *S3Storage) check(resp *http.Response, key string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 %s %s: %s: %s", resp.Request.Method, key, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (s *S3Storage) objectPath(key string) string {
+	path := "/" + uriEncode(s.Bucket, false)
+	if key != "" {
+		path += "/" + uriEncode(key, false)
+	} else {
+		path += "/"
+	}
+	return path
+}
+
+// sign derives the SigV4 signing key for the request date and signs the canonical request
+func (s *S3Storage) sign(now time.Time, amzDate, scope, canonicalRequest string) string {
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters; slashes are kept unless encodeSlash is set
+func uriEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
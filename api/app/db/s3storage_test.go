@@ -0,0 +1,332 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "ap-southeast-1"
+	testBucket    = "voting-kiosk"
+)
+
+// fakeS3 is a path-style S3 bucket that checks every request's SigV4 signature and payload hash the
+// way S3 does, and lists keys pageSize at a time
+type fakeS3 struct {
+	t        *testing.T
+	pageSize int
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifySigV4(r, body); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	bucketPrefix := "/" + testBucket + "/"
+	if !strings.HasPrefix(r.URL.Path, bucketPrefix) {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, bucketPrefix)
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.objects[key]; !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+f.pageSize, len(keys))
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{IsTruncated: end < len(keys)}
+	for _, key := range keys[start:end] {
+		result.Contents = append(result.Contents, content{Key: key, Size: len(f.objects[key]), LastModified: "2025-03-01T08:00:00.000Z"})
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verifySigV4 recomputes a request's signature from what arrived on the wire
+func verifySigV4(r *http.Request, body []byte) error {
+	payloadHash := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(payloadHash[:]) {
+		return fmt.Errorf("x-amz-content-sha256 is %q, want the SHA-256 of the %d byte body", got, len(body))
+	}
+
+	authorization, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("Authorization header is not AWS4-HMAC-SHA256")
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(authorization, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	date, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("x-amz-date %q: %v", amzDate, err)
+	}
+	scope := date.Format("20060102") + "/" + testRegion + "/s3/aws4_request"
+	if fields["Credential"] != testAccessKey+"/"+scope {
+		return fmt.Errorf("credential is %q", fields["Credential"])
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !strings.Contains(";"+fields["SignedHeaders"]+";", ";"+required+";") {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		sortedQuery(r),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{date.Format("20060102"), testRegion, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if want := hex.EncodeToString(key); fields["Signature"] != want {
+		return fmt.Errorf("signature is %q, want %q", fields["Signature"], want)
+	}
+	return nil
+}
+
+// sortedQuery encodes the request's query parameters sorted by name, spaces as %20
+func sortedQuery(r *http.Request) string {
+	var parts []string
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	sort.Strings(parts)
+	return strings.ReplaceAll(strings.Join(parts, "&"), "+", "%20")
+}
+
+func newTestS3(t *testing.T, prefix string) (*S3Storage, *fakeS3) {
+	fake := &fakeS3{t: t, pageSize: 2, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return &S3Storage{
+		Endpoint:  server.URL,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		Prefix:    prefix,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		URLExpiry: time.Minute,
+		Client:    server.Client(),
+	}, fake
+}
+
+func TestS3StoragePutGetDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		body io.Reader
+		want []byte
+	}{
+		{"seekable body", "backup 2025-03-01.xlsx", bytes.NewReader([]byte("workbook")), []byte("workbook")},
+		{"streamed body", "photo.jpg", io.MultiReader(strings.NewReader("jpeg "), strings.NewReader("data")), []byte("jpeg data")},
+		{"empty body", "empty.txt", bytes.NewReader(nil), []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, fake := newTestS3(t, "backups/")
+
+			if err := storage.Put(tt.key, tt.body, "application/octet-stream"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			if stored := fake.objects["backups/"+tt.key]; !bytes.Equal(stored, tt.want) {
+				t.Fatalf("stored %q, want %q", stored, tt.want)
+			}
+
+			reader, err := storage.Get(tt.key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil || !bytes.Equal(got, tt.want) {
+				t.Fatalf("Get returned %q, %v; want %q", got, err, tt.want)
+			}
+
+			if err := storage.Delete(tt.key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := storage.Get(tt.key); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("Get after Delete returned %v, want ErrObjectNotFound", err)
+			}
+		})
+	}
+}
+
+func TestS3StorageNotFound(t *testing.T) {
+	storage, _ := newTestS3(t, "photos/")
+
+	if _, err := storage.Get("missing.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get returned %v, want ErrObjectNotFound", err)
+	}
+	if err := storage.Delete("missing.jpg"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Delete returned %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestS3StorageRejectsInvalidKeys(t *testing.T) {
+	storage, fake := newTestS3(t, "photos/")
+
+	for _, key := range []string{"", "..", "../backups/x.xlsx", "a/b.jpg"} {
+		if err := storage.Put(key, strings.NewReader("x"), "image/jpeg"); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if fake.requests != 0 {
+		t.Errorf("%d requests were sent for invalid keys", fake.requests)
+	}
+}
+
+func TestS3StorageListPaginates(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []string
+		wantPages int
+	}{
+		{"empty", nil, 1},
+		{"one page", []string{"a.jpg"}, 1},
+		{"exact pages", []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}, 2},
+		{"partial last page", []string{"e.jpg", "a.jpg", "c.jpg", "b.jpg", "d.jpg"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, fake := newTestS3(t, "photos/")
+			for _, key := range tt.keys {
+				fake.objects["photos/"+key] = []byte(key)
+			}
+			// Objects of the other store share the bucket and must not be listed
+			fake.objects["backups/backup.xlsx"] = []byte("workbook")
+
+			fake.requests = 0
+			objects, err := storage.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if fake.requests != tt.wantPages {
+				t.Errorf("List made %d requests, want %d", fake.requests, tt.wantPages)
+			}
+
+			want := append([]string{}, tt.keys...)
+			sort.Strings(want)
+			if len(objects) != len(want) {
+				t.Fatalf("List returned %d objects, want %d", len(objects), len(want))
+			}
+			for i, object := range objects {
+				if object.Key != want[i] || object.Size != int64(len(want[i])) {
+					t.Errorf("object %d is %s (%d bytes), want %s (%d bytes)", i, object.Key, object.Size, want[i], len(want[i]))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// ErrObjectNotFound is returned when a storage key does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// StoredObject describes one object in a storage backend
+type StoredObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps candidate photos and backup files. Keys are flat file names.
+type Storage interface {
+	Put(key string, r io.Reader, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	List() ([]StoredObject, error)
+	// URL returns an address clients can fetch the object from: a cache-friendly path served by the API
+	// for local storage, or a short-lived signed URL for S3-compatible storage
+	URL(key string) (string, error)
+}
+
+var (
+	// Photos stores candidate photos and thumbnails
+	Photos Storage
+	// Backups stores generated election backups
+	Backups Storage
+)
+
+// validKey refuses keys that could escape the storage root
+func validKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) || strings.Contains(key, "..") {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	return nil
+}
+
+// InitStorage sets up the photo and backup stores from the storage.* settings
+func InitStorage() {
+	backend := "local"
+	if revel.Config != nil {
+		backend = revel.Config.StringDefault("storage.backend", "local")
+	}
+
+	switch backend {
+	case "local":
+		// Photos are served by the API under /uploads, so they live with its data rather than in the web app's public folder
+		Photos = &LocalStorage{Dir: storageDir("storage.local.photos", "data/uploads"), BaseURL: "/uploads"}
+		// Backups are never served directly; admins download them through BackupController.DownloadBackup
+		Backups = &LocalStorage{Dir: storageDir("storage.local.backups", "backup")}
+	case "s3":
+		photos, err := NewS3Storage("photos/")
+		if err != nil {
+			revel.AppLog.Fatal("❌ Failed to configure photo storage:", "error", err)
+		}
+		backups, err := NewS3Storage("backups/")
+		if err != nil {
+			revel.AppLog.Fatal("❌ Failed to configure backup storage:", "error", err)
+		}
+		Photos, Backups = photos, backups
+	default:
+		revel.AppLog.Fatal("❌ Unknown storage.backend:", "backend", backend)
+	}
+}
+
+// storageDir resolves a configured directory, relative paths being taken from the application's base path
+func storageDir(key, fallback string) string {
+	dir := fallback
+	if revel.Config != nil {
+		dir = revel.Config.StringDefault(key, fallback)
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(revel.BasePath, dir)
+	}
+	return dir
+}
+
+// LocalStorage keeps objects as files in a directory. Objects are served by the API under BaseURL.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s *LocalStorage) Put(key string, r io.Reader, contentType string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// Writing to a temporary file first means readers never see a partly written object
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, key))
+}
+
+func (s *LocalStorage) Get(key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(s.Dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.Dir, key))
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+func (s *LocalStorage) List() ([]StoredObject, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return []StoredObject{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	objects := []StoredObject{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, StoredObject{Key: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *LocalStorage) URL(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return s.BaseURL + "/" + url.PathEscape(key), nil
+}
+
+// PutBytes stores data under key
+func PutBytes(storage Storage, key string, data []byte, contentType string) error {
+	return storage.Put(key, bytes.NewReader(data), contentType)
+}
+
+// ImmutableKey reports whether a photo key names its content, so it can be cached forever
+func ImmutableKey(key string) bool {
+	name := strings.TrimSuffix(strings.TrimSuffix(key, ".jpg"), "_thumb")
+	if len(name) != 32 {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
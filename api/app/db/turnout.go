@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+
+	"api/app/models"
+
+	"github.com/revel/revel"
+)
+
+// departmentKey maps a voter's program to the department key used in results, e.g. "coe"
+func departmentKey(program string) string {
+	column, err := IdentifyDepartment(program)
+	if err != nil {
+		return "unknown"
+	}
+	for _, department := range departmentColumns {
+		if department.Column == column {
+			return department.Key
+		}
+	}
+	return "unknown"
+}
+
+// GetTurnout computes registered vs voted per department and program, plus ballots cast per hour
+func GetTurnout() (models.TurnoutStats, error) {
+	stats := models.TurnoutStats{
+		Departments: []models.TurnoutGroup{},
+		Programs:    []models.TurnoutGroup{},
+		Hourly:      []models.TurnoutHour{},
+	}
+
+	rows, err := DB.Query(`SELECT program, COUNT(*), COALESCE(SUM(has_voted), 0) FROM voters GROUP BY program ORDER BY program`)
+	if err != nil {
+		return stats, err
+	}
+
+	departments := make(map[string]*models.TurnoutGroup)
+	for rows.Next() {
+		var program models.TurnoutGroup
+		if err := rows.Scan(&program.Name, &program.Registered, &program.Voted); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		program.Percentage = Percentage(program.Voted, program.Registered)
+		stats.Programs = append(stats.Programs, program)
+
+		key := departmentKey(program.Name)
+		if departments[key] == nil {
+			departments[key] = &models.TurnoutGroup{Name: key}
+		}
+		departments[key].Registered += program.Registered
+		departments[key].Voted += program.Voted
+
+		stats.Overall.Registered += program.Registered
+		stats.Overall.Voted += program.Voted
+	}
+	rows.Close()
+
+	for _, department := range departments {
+		department.Percentage = Percentage(department.Voted, department.Registered)
+		stats.Departments = append(stats.Departments, *department)
+	}
+	sort.Slice(stats.Departments, func(i, j int) bool {
+		return stats.Departments[i].Name < stats.Departments[j].Name
+	})
+	stats.Overall.Percentage = Percentage(stats.Overall.Voted, stats.Overall.Registered)
+
+	rows, err = DB.Query(`SELECT DATE_FORMAT(cast_at, '%Y-%m-%d %H:00') AS hour, COUNT(*) FROM ballots GROUP BY hour ORDER BY hour`)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hour models.TurnoutHour
+		if err := rows.Scan(&hour.Hour, &hour.Ballots); err != nil {
+			return stats, err
+		}
+		stats.Hourly = append(stats.Hourly, hour)
+	}
+
+	return stats, nil
+}
+
+// Fetch Turnout
+func GetTurnoutData() [][]string {
+	stats, err := GetTurnout()
+	if err != nil {
+		revel.AppLog.Errorf("Failed to compute turnout: %v", err)
+		return nil
+	}
+
+	data := [][]string{
+		{"Group", "Name", "Registered", "Voted", "Turnout %"},
+		{"Overall", "All Voters", fmt.Sprintf("%d", stats.Overall.Registered), fmt.Sprintf("%d", stats.Overall.Voted), fmt.Sprintf("%.2f", stats.Overall.Percentage)},
+	}
+	for _, department := range stats.Departments {
+		data = append(data, []string{"Department", SanitizeCellValue(department.Name), fmt.Sprintf("%d", department.Registered), fmt.Sprintf("%d", department.Voted), fmt.Sprintf("%.2f", department.Percentage)})
+	}
+	for _, program := range stats.Programs {
+		data = append(data, []string{"Program", SanitizeCellValue(program.Name), fmt.Sprintf("%d", program.Registered), fmt.Sprintf("%d", program.Voted), fmt.Sprintf("%.2f", program.Percentage)})
+	}
+	for _, hour := range stats.Hourly {
+		data = append(data, []string{"Hourly Ballots", SanitizeCellValue(hour.Hour), "", fmt.Sprintf("%d", hour.Ballots), ""})
+	}
+
+	return data
+}
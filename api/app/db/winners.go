@@ -0,0 +1,294 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"api/app/models"
+)
+
+const (
+	OutcomeDecided      = "decided"
+	OutcomeTied         = "tied"
+	OutcomeResolved     = "resolved"
+	OutcomeNoCandidates = "no_candidates"
+)
+
+// ErrInvalidTieBreak is wrapped by every tie-break validation failure
+var ErrInvalidTieBreak = errors.New("invalid tie-break")
+
+// Accepted ways of settling a tie
+var TieBreakMethods = map[string]bool{
+	"coin_toss": true,
+	"runoff":    true,
+	"draw_lots": true,
+	"other":     true,
+}
+
+// rankCandidates orders candidates by votes and assigns competition ranks (1, 2, 2, 4)
+func rankCandidates(candidates []models.RankedCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].TotalVotes != candidates[j].TotalVotes {
+			return candidates[i].TotalVotes > candidates[j].TotalVotes
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	total := 0
+	for _, candidate := range candidates {
+		total += candidate.TotalVotes
+	}
+	for i := range candidates {
+		candidates[i].Percentage = Percentage(candidates[i].TotalVotes, total)
+		if i > 0 && candidates[i].TotalVotes == candidates[i-1].TotalVotes {
+			candidates[i].Rank = candidates[i-1].Rank
+		} else {
+			candidates[i].Rank = i + 1
+		}
+	}
+}
+
+// decidePosition fills the seats of a position from ranked candidates. A tie at the last seat
+// leaves those seats open unless a recorded tie-break names the winners among the tied candidates.
+// Withdrawn candidates are ranked and reported with their votes but never fill a seat.
+func decidePosition(position string, seats int, candidates []models.RankedCandidate, tieBreak *models.TieBreak) models.PositionOutcome {
+	rankCandidates(candidates)
+
+	outcome := models.PositionOutcome{
+		Position:       position,
+		Seats:          seats,
+		Candidates:     candidates,
+		Winners:        []models.RankedCandidate{},
+		TiedCandidates: []string{},
+	}
+
+	eligible := make([]models.RankedCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if !candidate.Withdrawn {
+			eligible = append(eligible, candidate)
+		}
+	}
+	candidates = eligible
+
+	if len(candidates) == 0 {
+		outcome.Status = OutcomeNoCandidates
+		outcome.OpenSeats = seats
+		return outcome
+	}
+
+	if len(candidates) <= seats {
+		outcome.Status = OutcomeDecided
+		outcome.Winners = append(outcome.Winners, candidates...)
+		outcome.Margin = candidates[len(candidates)-1].TotalVotes
+		outcome.OpenSeats = seats - len(candidates)
+		return outcome
+	}
+
+	cutoff := candidates[seats-1].TotalVotes
+	outcome.Margin = cutoff - candidates[seats].TotalVotes
+	if outcome.Margin > 0 {
+		outcome.Status = OutcomeDecided
+		outcome.Winners = append(outcome.Winners, candidates[:seats]...)
+		return outcome
+	}
+
+	outcome.Tie = true
+	tied := make(map[string]models.RankedCandidate)
+	for _, candidate := range candidates {
+		if candidate.TotalVotes > cutoff {
+			outcome.Winners = append(outcome.Winners, candidate)
+		} else if candidate.TotalVotes == cutoff {
+			outcome.TiedCandidates = append(outcome.TiedCandidates, candidate.PositionName)
+			tied[candidate.PositionName] = candidate
+		}
+	}
+	outcome.OpenSeats = seats - len(outcome.Winners)
+
+	if tieBreak != nil && len(tieBreak.Winners) == outcome.OpenSeats {
+		for _, positionName := range tieBreak.Winners {
+			if _, ok := tied[positionName]; !ok {
+				// The recorded resolution no longer matches the counts, so the tie stays open
+				outcome.Status = OutcomeTied
+				return outcome
+			}
+		}
+		for _, positionName := range tieBreak.Winners {
+			outcome.Winners = append(outcome.Winners, tied[positionName])
+		}
+		outcome.OpenSeats = 0
+		outcome.TieBreak = tieBreak
+		outcome.Status = OutcomeResolved
+		return outcome
+	}
+
+	outcome.Status = OutcomeTied
+	return outcome
+}
+
+func getTieBreaks() (map[string]*models.TieBreak, error) {
+	rows, err := DB.Query(`SELECT position, winners, method, COALESCE(notes, ''), resolved_by, resolved_at FROM tie_breaks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tieBreaks := make(map[string]*models.TieBreak)
+	for rows.Next() {
+		var tieBreak models.TieBreak
+		var winnersJSON string
+		if err := rows.Scan(&tieBreak.Position, &winnersJSON, &tieBreak.Method, &tieBreak.Notes, &tieBreak.ResolvedBy, &tieBreak.ResolvedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(winnersJSON), &tieBreak.Winners); err != nil {
+			return nil, fmt.Errorf("invalid tie-break winners for %s: %w", tieBreak.Position, err)
+		}
+		tieBreaks[tieBreak.Position] = &tieBreak
+	}
+
+	return tieBreaks, nil
+}
+
+// ComputeOutcomes ranks the candidates of every position and decides its winners
+func ComputeOutcomes() ([]models.PositionOutcome, error) {
+	tieBreaks, err := getTieBreaks()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`SELECT v.position_name, v.name, v.position, v.total_votes, c.withdrawn_at IS NOT NULL FROM votes v
+		LEFT JOIN candidates c ON c.id = v.candidate_id ORDER BY v.position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []string
+	candidatesByPosition := make(map[string][]models.RankedCandidate)
+	for rows.Next() {
+		var candidate models.RankedCandidate
+		var position string
+		var withdrawn sql.NullBool
+		if err := rows.Scan(&candidate.PositionName, &candidate.Name, &position, &candidate.TotalVotes, &withdrawn); err != nil {
+			return nil, err
+		}
+		if _, ok := candidatesByPosition[position]; !ok {
+			positions = append(positions, position)
+		}
+		candidate.Withdrawn = withdrawn.Bool
+		candidatesByPosition[position] = append(candidatesByPosition[position], candidate)
+	}
+
+	rules, err := positionRules()
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := []models.PositionOutcome{}
+	for _, position := range positions {
+		rule := ruleFor(rules, position)
+		candidates := candidatesByPosition[position]
+
+		if rule.Tabulation == TabulationRankedChoice {
+			var positionNames []string
+			for _, candidate := range candidates {
+				if !candidate.Withdrawn {
+					positionNames = append(positionNames, candidate.PositionName)
+				}
+			}
+			ballots, err := rankedBallots(position)
+			if err != nil {
+				return nil, err
+			}
+			candidates = rankedChoiceStandings(candidates, TabulateRankedChoice(position, positionNames, ballots))
+		}
+
+		outcomes = append(outcomes, decidePosition(position, rule.Seats, candidates, tieBreaks[position]))
+	}
+
+	return outcomes, nil
+}
+
+// FinalWinners flattens decided outcomes into the official winners list
+func FinalWinners(outcomes []models.PositionOutcome) []models.Winner {
+	winners := []models.Winner{}
+	for _, outcome := range outcomes {
+		tieBroken := make(map[string]bool)
+		if outcome.TieBreak != nil {
+			for _, positionName := range outcome.TieBreak.Winners {
+				tieBroken[positionName] = true
+			}
+		}
+
+		for _, candidate := range outcome.Winners {
+			decidedBy := "votes"
+			if tieBroken[candidate.PositionName] {
+				decidedBy = "tie_break"
+			}
+			winners = append(winners, models.Winner{
+				Position:     outcome.Position,
+				PositionName: candidate.PositionName,
+				Name:         candidate.Name,
+				TotalVotes:   candidate.TotalVotes,
+				DecidedBy:    decidedBy,
+			})
+		}
+	}
+	return winners
+}
+
+// SaveTieBreak records the official resolution of a tie after checking it against the current counts
+func SaveTieBreak(tieBreak models.TieBreak) error {
+	outcomes, err := ComputeOutcomes()
+	if err != nil {
+		return err
+	}
+
+	for _, outcome := range outcomes {
+		if outcome.Position != tieBreak.Position {
+			continue
+		}
+		if !outcome.Tie {
+			return fmt.Errorf("%w: %s is not tied", ErrInvalidTieBreak, tieBreak.Position)
+		}
+
+		openSeats := outcome.OpenSeats
+		if outcome.Status == OutcomeResolved {
+			openSeats = len(outcome.TieBreak.Winners)
+		}
+		if len(tieBreak.Winners) != openSeats {
+			return fmt.Errorf("%w: exactly %d winner(s) must be chosen for %s", ErrInvalidTieBreak, openSeats, tieBreak.Position)
+		}
+
+		tied := make(map[string]bool)
+		for _, positionName := range outcome.TiedCandidates {
+			tied[positionName] = true
+		}
+		for _, positionName := range tieBreak.Winners {
+			if !tied[positionName] {
+				return fmt.Errorf("%w: %s is not one of the tied candidates", ErrInvalidTieBreak, positionName)
+			}
+		}
+
+		winnersJSON, err := json.Marshal(tieBreak.Winners)
+		if err != nil {
+			return err
+		}
+
+		_, err = DB.Exec(`
+			INSERT INTO tie_breaks (position, winners, method, notes, resolved_by, resolved_at)
+			VALUES (?, ?, ?, ?, ?, NOW())
+			ON DUPLICATE KEY UPDATE
+				winners = VALUES(winners),
+				method = VALUES(method),
+				notes = VALUES(notes),
+				resolved_by = VALUES(resolved_by),
+				resolved_at = VALUES(resolved_at)
+		`, tieBreak.Position, string(winnersJSON), tieBreak.Method, tieBreak.Notes, tieBreak.ResolvedBy)
+		return err
+	}
+
+	return fmt.Errorf("%w: unknown position %s", ErrInvalidTieBreak, tieBreak.Position)
+}
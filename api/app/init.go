@@ -3,7 +3,6 @@ package app
 import (
 	"api/app/controllers"
 	"api/app/db" // ✅ Import the db package
-	"net/http"
 
 	"github.com/revel/revel"
 )
@@ -28,8 +27,10 @@ func init() {
 
 	revel.OnAppStart(func() {
 		db.InitDB()                    // Initialize DB
+		db.InitStorage()               // Photo and backup storage, served through CandidatesController.ServePhoto
+		db.InitBackupEncryption()      // Fail fast on a malformed BACKUP_ENCRYPTION_KEY
 		db.RecoverElectionTimer(db.DB) // Recover timer after DB is initialized
-		http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads/"))))
+		db.StartBackupScheduler()      // Automatic backups while the election is open
 	})
 	revel.InterceptMethod((*controllers.AdminController).SetDB, revel.BEFORE)
 	revel.InterceptMethod((*controllers.CandidatesController).SetDB, revel.BEFORE)
@@ -37,6 +38,8 @@ func init() {
 	revel.InterceptMethod((*controllers.SigninController).SetDB, revel.BEFORE)
 	revel.InterceptMethod((*controllers.VotingController).SetDB, revel.BEFORE)
 	revel.InterceptMethod((*controllers.RegistrationController).SetDB, revel.BEFORE)
+	revel.InterceptMethod((*controllers.KioskController).SetDB, revel.BEFORE)
+	revel.InterceptMethod((*controllers.BackupController).SetDB, revel.BEFORE)
 }
 
 // HeaderFilter adds common security headers
@@ -54,7 +57,8 @@ var CORSFilter = func(c *revel.Controller, fc []revel.Filter) {
 	//c.Response.Out.Header().Add("Access-Control-Allow-Origin", "*")
 	c.Response.Out.Header().Add("Access-Control-Allow-Origin", "https://votingkioskthesis.vercel.app")
 	c.Response.Out.Header().Add("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	c.Response.Out.Header().Add("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, Ngrok-Skip-Browser-Warning")
+	c.Response.Out.Header().Add("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, Ngrok-Skip-Browser-Warning, "+
+		"X-Kiosk-ID, X-Kiosk-Timestamp, X-Kiosk-Nonce, X-Kiosk-Signature")
 	c.Response.Out.Header().Add("Access-Control-Allow-Credentials", "true")
 
 	// Handle preflight OPTIONS request
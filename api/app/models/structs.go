@@ -1,14 +1,80 @@
 package models
 
+import "encoding/json"
+
 type Candidate struct {
-	PositionName string   `json:"position_name"`
-	Name         string   `json:"name"`
-	Position     string   `json:"position"`
-	YearLevel    string   `json:"year_level"`
-	Program      string   `json:"program"`
-	Credentials  []string `json:"credentials"`
-	PhotoURL     string   `json:"photo_url"`
-	Partylist    string   `json:"partylist"`
+	ID           int64        `json:"id"`
+	PositionName string       `json:"position_name"`
+	Name         string       `json:"name"`
+	Position     string       `json:"position"`
+	YearLevel    string       `json:"year_level"`
+	Program      string       `json:"program"`
+	Credentials  []Credential `json:"credentials"`
+	PhotoURL     string       `json:"photo_url"`
+	ThumbnailURL string       `json:"thumbnail_url"`
+	PhotoSrc     string       `json:"photo_src,omitempty"`
+	ThumbnailSrc string       `json:"thumbnail_src,omitempty"`
+	Partylist    string       `json:"partylist"`
+	PartylistID  *int64       `json:"partylist_id"`
+	Withdrawn    bool         `json:"withdrawn"`
+	WithdrawnAt  string       `json:"withdrawn_at,omitempty"`
+}
+
+type Credential struct {
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Year        int    `json:"year,omitempty"`
+	Description string `json:"description,omitempty"`
+	Order       int    `json:"order"`
+}
+
+// UnmarshalJSON also accepts the legacy form, where a credential was a plain string
+func (c *Credential) UnmarshalJSON(data []byte) error {
+	var title string
+	if err := json.Unmarshal(data, &title); err == nil {
+		*c = Credential{Type: "other", Title: title}
+		return nil
+	}
+
+	type credential Credential
+	return json.Unmarshal(data, (*credential)(c))
+}
+
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Username  string          `json:"admin_username"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type Partylist struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Acronym  string `json:"acronym"`
+	LogoURL  string `json:"logo_url"`
+	Platform string `json:"platform"`
+	Color    string `json:"color"`
+}
+
+type PartylistSlate struct {
+	PartylistID *int64      `json:"partylist_id"`
+	Name        string      `json:"name"`
+	Acronym     string      `json:"acronym"`
+	Color       string      `json:"color"`
+	Candidates  []Candidate `json:"candidates"`
+}
+
+type PartylistResult struct {
+	PartylistID *int64   `json:"partylist_id"`
+	Name        string   `json:"name"`
+	Color       string   `json:"color"`
+	Candidates  int      `json:"candidates"`
+	TotalVotes  int      `json:"total_votes"`
+	VoteShare   float64  `json:"vote_share"`
+	SeatsWon    int      `json:"seats_won"`
+	Winners     []string `json:"winners"`
 }
 
 type Voter struct {
@@ -19,34 +85,215 @@ type Voter struct {
 	HasVoted        bool   `json:"has_voted"`
 }
 
+type VoterLookup struct {
+	MaskedName  string `json:"masked_name"`
+	Eligibility string `json:"eligibility"`
+}
+
+type Kiosk struct {
+	KioskID   string `json:"kiosk_id"`
+	Name      string `json:"name"`
+	IsRevoked bool   `json:"is_revoked"`
+	CreatedAt string `json:"created_at"`
+}
+
+type KioskHeartbeat struct {
+	SoftwareVersion string `json:"software_version"`
+	LastBallotAt    string `json:"last_ballot_at"`
+	ReaderStatus    string `json:"reader_status"`
+	QueueLength     int    `json:"queue_length"`
+}
+
+type KioskStatus struct {
+	KioskID              string `json:"kiosk_id"`
+	Name                 string `json:"name"`
+	IsRevoked            bool   `json:"is_revoked"`
+	Online               bool   `json:"online"`
+	LastHeartbeatAt      string `json:"last_heartbeat_at"`
+	SecondsSinceSeen     int64  `json:"seconds_since_seen"`
+	SoftwareVersion      string `json:"software_version"`
+	ReaderStatus         string `json:"reader_status"`
+	QueueLength          int    `json:"queue_length"`
+	ReportedLastBallotAt string `json:"reported_last_ballot_at"`
+	LastBallotAt         string `json:"last_ballot_at"`
+	BallotsCast          int    `json:"ballots_cast"`
+}
+
 type ExcelVoters struct {
-	StudentID   string
-	StudentName string
-	Program     string
-	HasVoted    bool
+	StudentID       string `json:"student_id"`
+	StudentName     string `json:"student_name"`
+	Program         string `json:"program"`
+	HasVoted        bool   `json:"has_voted"`
+	FingerprintHash string `json:"fingerprint_hash,omitempty"`
 }
 
 type Votes struct {
+	BallotID         string `json:"ballot_id"`
 	GovernorVote     string `json:"governor_vote"`
 	ViceGovernorVote string `json:"vice_governor_vote"`
 	BoardMemberVote  string `json:"board_member_vote"`
 	Program          string `json:"program"`
 	StudentID        string `json:"student_id"`
+
+	Selections map[string][]string `json:"selections"`
+}
+
+type Position struct {
+	Position      string `json:"position"`
+	Seats         int    `json:"seats"`
+	MaxSelections int    `json:"max_selections"`
+	Tabulation    string `json:"tabulation"`
+}
+
+type RankedChoiceRound struct {
+	Round      int            `json:"round"`
+	Counts     map[string]int `json:"counts"`
+	Exhausted  int            `json:"exhausted"`
+	Eliminated []string       `json:"eliminated"`
+	Winner     string         `json:"winner,omitempty"`
+}
+
+type RankedChoiceResult struct {
+	Position string              `json:"position"`
+	Rounds   []RankedChoiceRound `json:"rounds"`
+	Winner   string              `json:"winner,omitempty"`
+	Tied     []string            `json:"tied,omitempty"`
 }
 
 type ExcelVotes struct {
-	PositionName string
-	Name         string
-	Position     string
-	COEVotes     int
-	CBAVotes     int
-	CICSVotes    int
-	CITVotes     int
-	COEDBSED     int
-	COEDBEED     int
-	COEDBPED     int
-	COEDVotes    int
-	TotalVotes   int
+	PositionName string `json:"position_name"`
+	Name         string `json:"name"`
+	Position     string `json:"position"`
+	COEVotes     int    `json:"coe_votes"`
+	CBAVotes     int    `json:"cba_votes"`
+	CICSVotes    int    `json:"cics_votes"`
+	CITVotes     int    `json:"cit_votes"`
+	COEDBSED     int    `json:"coed_bsed"`
+	COEDBEED     int    `json:"coed_beed"`
+	COEDBPED     int    `json:"coed_bped"`
+	COEDVotes    int    `json:"coed_votes"`
+	TotalVotes   int    `json:"total_votes"`
+}
+
+type ResultsCandidate struct {
+	CandidateID  int64          `json:"candidate_id"`
+	PositionName string         `json:"position_name"`
+	Name         string         `json:"name"`
+	Departments  map[string]int `json:"departments"`
+	TotalVotes   int            `json:"total_votes"`
+	Percentage   float64        `json:"percentage"`
+}
+
+type ResultsPosition struct {
+	Position        string              `json:"position"`
+	Seats           int                 `json:"seats"`
+	MaxSelections   int                 `json:"max_selections"`
+	Tabulation      string              `json:"tabulation"`
+	Rounds          []RankedChoiceRound `json:"rounds,omitempty"`
+	Candidates      []ResultsCandidate  `json:"candidates"`
+	TotalVotes      int                 `json:"total_votes"`
+	EligibleBallots int                 `json:"eligible_ballots"`
+	Abstentions     int                 `json:"abstentions"`
+}
+
+type Turnout struct {
+	Registered int     `json:"registered"`
+	Voted      int     `json:"voted"`
+	Percentage float64 `json:"percentage"`
+}
+
+type TurnoutGroup struct {
+	Name       string  `json:"name"`
+	Registered int     `json:"registered"`
+	Voted      int     `json:"voted"`
+	Percentage float64 `json:"percentage"`
+}
+
+type TurnoutHour struct {
+	Hour    string `json:"hour"`
+	Ballots int    `json:"ballots"`
+}
+
+type TurnoutStats struct {
+	Overall     Turnout        `json:"overall"`
+	Departments []TurnoutGroup `json:"departments"`
+	Programs    []TurnoutGroup `json:"programs"`
+	Hourly      []TurnoutHour  `json:"hourly"`
+}
+
+type Results struct {
+	Positions   []ResultsPosition `json:"positions"`
+	BallotsCast int               `json:"ballots_cast"`
+	Turnout     Turnout           `json:"turnout"`
+	GeneratedAt string            `json:"generated_at"`
+}
+
+type RankedCandidate struct {
+	PositionName string  `json:"position_name"`
+	Name         string  `json:"name"`
+	TotalVotes   int     `json:"total_votes"`
+	Percentage   float64 `json:"percentage"`
+	Rank         int     `json:"rank"`
+	Withdrawn    bool    `json:"withdrawn"`
+}
+
+type TieBreak struct {
+	Position   string   `json:"position"`
+	Winners    []string `json:"winners"`
+	Method     string   `json:"method"`
+	Notes      string   `json:"notes"`
+	ResolvedBy string   `json:"resolved_by"`
+	ResolvedAt string   `json:"resolved_at"`
+}
+
+type PositionOutcome struct {
+	Position       string            `json:"position"`
+	Seats          int               `json:"seats"`
+	Status         string            `json:"status"`
+	Candidates     []RankedCandidate `json:"candidates"`
+	Winners        []RankedCandidate `json:"winners"`
+	Margin         int               `json:"margin"`
+	Tie            bool              `json:"tie"`
+	TiedCandidates []string          `json:"tied_candidates"`
+	OpenSeats      int               `json:"open_seats"`
+	TieBreak       *TieBreak         `json:"tie_break,omitempty"`
+}
+
+type Winner struct {
+	Position     string `json:"position"`
+	PositionName string `json:"position_name"`
+	Name         string `json:"name"`
+	TotalVotes   int    `json:"total_votes"`
+	DecidedBy    string `json:"decided_by"`
+}
+
+type CanvassDocument struct {
+	VotingStart   string       `json:"voting_start"`
+	VotingEnd     string       `json:"voting_end"`
+	CertifiedAt   string       `json:"certified_at"`
+	CertifiedBy   string       `json:"certified_by"`
+	BallotsCast   int          `json:"ballots_cast"`
+	BallotsDigest string       `json:"ballots_digest"`
+	Turnout       Turnout      `json:"turnout"`
+	Counts        []ExcelVotes `json:"counts"`
+	Winners       []Winner     `json:"winners"`
+}
+
+type CanvassRecord struct {
+	ID        int64  `json:"id"`
+	Document  string `json:"document"`
+	Digest    string `json:"digest"`
+	Signature string `json:"signature"`
+	PublicKey string `json:"public_key"`
+}
+
+type CanvassVerification struct {
+	Valid          bool `json:"valid"`
+	DigestValid    bool `json:"digest_valid"`
+	SignatureValid bool `json:"signature_valid"`
+	KeyMatches     bool `json:"key_matches"`
+	BallotsMatch   bool `json:"ballots_match"`
+	CountsMatch    bool `json:"counts_match"`
 }
 
 type Timeframe struct {
@@ -77,9 +324,142 @@ type PostCandidatesPayload struct {
 	CoeCandidates             []Candidate `json:"coeCandidates"`
 	PartylistLeft             string      `json:"partylistLeft"`
 	PartylistRight            string      `json:"partylistRight"`
+	Partylists                []Partylist `json:"partylists"`
+	Upsert                    bool        `json:"upsert"`
+}
+
+type CandidateSubmission struct {
+	Section   string
+	Index     int
+	Candidate Candidate
+}
+
+type CandidateIssue struct {
+	Section string `json:"section"`
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Error   string `json:"error"`
+}
+
+type CandidateSaveResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
 }
 
 type ExcelElectionSetting struct {
-	VotingStart string
-	VotingEnd   string
+	VotingStart string `json:"voting_start"`
+	VotingEnd   string `json:"voting_end"`
+}
+
+type RestoreIssue struct {
+	Sheet string `json:"sheet"`
+	Row   int    `json:"row,omitempty"`
+	Error string `json:"error"`
+}
+
+type RestoreTableDiff struct {
+	Table        string   `json:"table"`
+	Current      int      `json:"current"`
+	Backup       int      `json:"backup"`
+	AddedCount   int      `json:"added_count"`
+	RemovedCount int      `json:"removed_count"`
+	ChangedCount int      `json:"changed_count"`
+	Added        []string `json:"added"`
+	Removed      []string `json:"removed"`
+	Changed      []string `json:"changed"`
+}
+
+type RestorePreview struct {
+	Filename string             `json:"filename"`
+	Tables   []RestoreTableDiff `json:"tables"`
+	Warnings []string           `json:"warnings"`
+}
+
+type BackupFailure struct {
+	At      string `json:"at"`
+	Trigger string `json:"trigger"`
+	Error   string `json:"error"`
+}
+
+type BackupSchedule struct {
+	Enabled             bool            `json:"enabled"`
+	Interval            string          `json:"interval"`
+	KeepLast            int             `json:"keep_last"`
+	KeepDays            int             `json:"keep_days"`
+	ElectionOpen        bool            `json:"election_open"`
+	NextBackupAt        string          `json:"next_backup_at,omitempty"`
+	LastSuccessAt       string          `json:"last_success_at,omitempty"`
+	LastBackup          string          `json:"last_backup,omitempty"`
+	LastFailureAt       string          `json:"last_failure_at,omitempty"`
+	LastError           string          `json:"last_error,omitempty"`
+	ConsecutiveFailures int             `json:"consecutive_failures"`
+	RecentFailures      []BackupFailure `json:"recent_failures"`
+}
+
+type BackupElectionState struct {
+	Open        bool   `json:"open"`
+	Certified   bool   `json:"certified"`
+	VotingStart string `json:"voting_start,omitempty"`
+	VotingEnd   string `json:"voting_end,omitempty"`
+	BallotsCast int    `json:"ballots_cast"`
+}
+
+type BackupManifest struct {
+	Filename   string              `json:"filename"`
+	SHA256     string              `json:"sha256"`
+	Size       int64               `json:"size"`
+	Encrypted  bool                `json:"encrypted"`
+	Format     string              `json:"format"`
+	CreatedAt  string              `json:"created_at"`
+	CreatedBy  string              `json:"created_by"`
+	Trigger    string              `json:"trigger"`
+	AppVersion string              `json:"app_version"`
+	RowCounts  map[string]int      `json:"row_counts"`
+	Election   BackupElectionState `json:"election"`
+}
+
+type ElectionExport struct {
+	ExportedAt       string                 `json:"exported_at"`
+	AppVersion       string                 `json:"app_version"`
+	ElectionSettings []ExcelElectionSetting `json:"election_settings"`
+	Partylists       []Partylist            `json:"partylists"`
+	Candidates       []Candidate            `json:"candidates"`
+	Votes            []ExcelVotes           `json:"votes"`
+	Voters           []ExcelVoters          `json:"voters"`
+	Turnout          TurnoutStats           `json:"turnout"`
+	RankedChoice     []RankedChoiceResult   `json:"ranked_choice"`
+}
+
+type BackupListEntry struct {
+	Filename    string          `json:"filename"`
+	DownloadURL string          `json:"downloadUrl"`
+	ExpiresAt   string          `json:"expires_at"`
+	Size        int64           `json:"size"`
+	ModifiedAt  string          `json:"modified_at"`
+	Encrypted   bool            `json:"encrypted"`
+	Manifest    *BackupManifest `json:"manifest"`
+}
+
+type BackupVerification struct {
+	Filename    string   `json:"filename"`
+	Valid       bool     `json:"valid"`
+	HasManifest bool     `json:"has_manifest"`
+	SHA256      string   `json:"sha256"`
+	Size        int64    `json:"size"`
+	Problems    []string `json:"problems"`
+}
+
+type BackupJob struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Format         string `json:"format"`
+	StartedBy      string `json:"started_by"`
+	StartedAt      string `json:"started_at"`
+	FinishedAt     string `json:"finished_at,omitempty"`
+	CurrentSection string `json:"current_section,omitempty"`
+	SectionsDone   int    `json:"sections_done"`
+	SectionsTotal  int    `json:"sections_total"`
+	RowsWritten    int    `json:"rows_written"`
+	Filename       string `json:"filename,omitempty"`
+	Error          string `json:"error,omitempty"`
 }
@@ -1,18 +1,20 @@
 // realtime-server.js
 
+// Live tally updates are served by the Go API at /api/results-stream;
+// this server only answers fingerprint lookups for the kiosks.
+
 const WebSocket = require("ws");
-const http = require("http");
 const mysql = require("mysql2/promise");
 
 const WEBSOCKET_URL = process.env.REACT_APP_WEBSOCKET_URL;
 
 // 1. MySQL connection pool
 const db = mysql.createPool({
-  host: "localhost",
-  user: "root",
-  password: "!Database123", //new password
-  database: "voting_kiosk_db",
-  port: 3306,
+  host: process.env.DB_HOST,
+  user: process.env.DB_USER,
+  password: process.env.DB_PASSWORD,
+  database: process.env.DB_NAME,
+  port: process.env.DB_PORT,
 });
 
 // 2. WebSocket Server
@@ -66,45 +68,3 @@ wss.on("connection", (ws) => {
     console.log("Client disconnected");
   });
 });
-
-// 3. Broadcast helper (still useful for notify endpoint)
-wss.broadcast = function broadcast(data) {
-  wss.clients.forEach((client) => {
-    if (client.readyState === WebSocket.OPEN) {
-      client.send(data);
-    }
-  });
-};
-
-// 4. HTTP trigger for external broadcasts (optional)
-const server = http.createServer((req, res) => {
-  if (req.url === "/notify" && req.method === "POST") {
-    let body = "";
-
-    req.on("data", chunk => {
-      body += chunk;
-    });
-
-    req.on("end", () => {
-      try {
-        const parsed = JSON.parse(body);
-        const jsonString = JSON.stringify(parsed);
-        wss.broadcast(jsonString);
-        console.log("Broadcasted to clients:", jsonString);
-        res.writeHead(200);
-        res.end("Broadcasted");
-      } catch (err) {
-        console.error("Failed to parse JSON:", err);
-        res.writeHead(400);
-        res.end("Invalid JSON");
-      }
-    });
-  } else {
-    res.writeHead(404);
-    res.end("Not Found");
-  }
-});
-
-server.listen(3001, () => {
-  console.log("HTTP trigger listening on http://localhost:3001/notify");
-});
@@ -1,6 +1,7 @@
 import { Routes, Route, Link, useNavigate, useLocation } from "react-router-dom";
 import { useState,useEffect } from "react";
 import "./registration.css"
+import kioskFetch from "../../utils/kiosk-fetch";
 
 const API_URL = process.env.REACT_APP_API_URL;
 const HARDWARE_API = process.env.REACT_APP_HARDWARE_API;
@@ -74,7 +75,7 @@ const QRScannerUSB = () => {
         return;
     }
 
-    fetch(`${API_URL}/api/qr-api`, {
+    kioskFetch(`${API_URL}/api/qr-api`, {
       method: 'POST',
       headers: {
           "Content-Type": "application/json",
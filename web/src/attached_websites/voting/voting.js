@@ -1,5 +1,6 @@
 import React, { useState, useEffect } from "react";
 import ScanPage from "./scan-page";
+import kioskFetch from "../../utils/kiosk-fetch";
 import "./voting.css";
 
 const API_URL = process.env.REACT_APP_API_URL;
@@ -55,7 +56,7 @@ const VotingPage = ({ title, candidates, onVote, onBack, showControls }) => (
       <div className="governor-contents">
           {candidates.map((candidate, index) => (
               <div key={index} className={`candidate candidate-${index === 0 ? "left" : "right"}`}>
-                  <img src={`/uploads/${candidate.photo_url}`} alt={candidate.name} className="candidate-img larger" />
+                  <img src={candidate.photo_src || `/uploads/${candidate.photo_url}`} alt={candidate.name} className="candidate-img larger" />
                   <p className="candidate-name">{candidate.name}</p>
                   <button className="vote-button" onClick={() => onVote(candidate.name)}>
                       Vote
@@ -243,7 +244,7 @@ function Voting() {
     };
 
     try {
-      const response = await fetch(`${API_URL}/api/post-vote`, {
+      const response = await kioskFetch(`${API_URL}/api/post-vote`, {
         method: 'POST',
         headers: {
             'Content-Type': 'application/json',
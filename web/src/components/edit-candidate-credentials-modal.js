@@ -1,20 +1,25 @@
 import React, { useState } from "react";
 import "../styles/edit-candidate-modal.css";
 import fetchWithAuth from "../utils/fetch-with-auth";
+import { CREDENTIAL_TYPES, toCredential } from "../utils/credentials";
 
 const API_URL = process.env.REACT_APP_API_URL || "http://localhost:9000";
 
 function EditCandidateCredentialsModal({ candidate, onClose, onSave }) {
-  const [credentials, setCredentials] = useState(candidate.credentials || [""]);
+  const emptyCredential = () => ({ type: "other", title: "" });
 
-  const handleCredentialChange = (index, value) => {
+  const [credentials, setCredentials] = useState(
+    candidate.credentials?.length ? candidate.credentials.map(toCredential) : [emptyCredential()]
+  );
+
+  const handleCredentialChange = (index, field, value) => {
     const updatedCredentials = [...credentials];
-    updatedCredentials[index] = value;
+    updatedCredentials[index] = { ...updatedCredentials[index], [field]: value };
     setCredentials(updatedCredentials);
   };
 
   const handleAddCredential = () => {
-    setCredentials([...credentials, ""]);
+    setCredentials([...credentials, emptyCredential()]);
   };
 
   const handleRemoveCredential = (index) => {
@@ -26,9 +31,17 @@ function EditCandidateCredentialsModal({ candidate, onClose, onSave }) {
     e.preventDefault();
 
     try {
+      // Entries left blank are dropped; the order sent is the display order
       const payload = {
         position_name: candidate.position_name,
-        credentials,
+        credentials: credentials
+          .filter((cred) => cred.title.trim() !== "")
+          .map((cred, index) => ({
+            ...cred,
+            title: cred.title.trim(),
+            year: cred.year ? Number(cred.year) : undefined,
+            order: index + 1,
+          })),
       };
 
       const response = await fetchWithAuth(`${API_URL}/api/update-credentials`, {
@@ -62,12 +75,29 @@ function EditCandidateCredentialsModal({ candidate, onClose, onSave }) {
           {credentials.map((cred, index) => (
             <div key={index} className="edit-candidate-form-group">
               <label>Credential {index + 1}:</label>
+              <select
+                value={cred.type || "other"}
+                onChange={(e) => handleCredentialChange(index, "type", e.target.value)}
+              >
+                {Object.entries(CREDENTIAL_TYPES).map(([type, label]) => (
+                  <option key={type} value={type}>
+                    {label}
+                  </option>
+                ))}
+              </select>
               <input
                 type="text"
-                value={cred}
-                onChange={(e) => handleCredentialChange(index, e.target.value)}
+                placeholder="Title"
+                value={cred.title}
+                onChange={(e) => handleCredentialChange(index, "title", e.target.value)}
                 required
               />
+              <input
+                type="number"
+                placeholder="Year"
+                value={cred.year || ""}
+                onChange={(e) => handleCredentialChange(index, "year", e.target.value)}
+              />
               <button
                 type="button"
                 onClick={() => handleRemoveCredential(index)}
@@ -0,0 +1,19 @@
+// Kinds of credential the API accepts, with the label shown for each
+export const CREDENTIAL_TYPES = {
+  award: "Award",
+  position: "Position",
+  organization: "Organization",
+  education: "Education",
+  achievement: "Achievement",
+  other: "Other",
+};
+
+// Credentials come back from the API as { type, title, year, description, order } records.
+// Plain strings, as older data held them, are still accepted.
+export const toCredential = (cred) =>
+  typeof cred === "string" ? { type: "other", title: cred } : cred;
+
+export const credentialLabel = (cred) => {
+  const { title, year } = toCredential(cred);
+  return year ? `${title} (${year})` : title;
+};
@@ -0,0 +1,58 @@
+// Kiosks sign every request to the API with the ID and secret an admin gets when enrolling the
+// kiosk. They are entered once on each kiosk device and kept in its local storage.
+const getKioskCredentials = () => {
+    let kioskId = localStorage.getItem("kioskId");
+    let kioskSecret = localStorage.getItem("kioskSecret");
+
+    if (!kioskId || !kioskSecret) {
+        kioskId = (window.prompt("Enter this kiosk's ID:") || "").trim();
+        kioskSecret = (window.prompt("Enter this kiosk's secret:") || "").trim();
+        if (!kioskId || !kioskSecret) {
+            throw new Error("This kiosk is not enrolled.");
+        }
+        localStorage.setItem("kioskId", kioskId);
+        localStorage.setItem("kioskSecret", kioskSecret);
+    }
+
+    return { kioskId, kioskSecret };
+};
+
+const toHex = (buffer) =>
+    Array.from(new Uint8Array(buffer), (byte) => byte.toString(16).padStart(2, "0")).join("");
+
+// The signature is an HMAC-SHA256 of the method, path, unix timestamp, nonce and SHA-256 of the body,
+// separated by newlines, as the API's KioskSignature computes it
+async function kioskFetch(url, options = {}) {
+    const { kioskId, kioskSecret } = getKioskCredentials();
+    const encoder = new TextEncoder();
+
+    const method = (options.method || "GET").toUpperCase();
+    const path = decodeURIComponent(new URL(url, window.location.origin).pathname);
+    const timestamp = Math.floor(Date.now() / 1000).toString();
+    const nonce = toHex(crypto.getRandomValues(new Uint8Array(16)));
+    const bodyHash = toHex(await crypto.subtle.digest("SHA-256", encoder.encode(options.body || "")));
+
+    const key = await crypto.subtle.importKey("raw", encoder.encode(kioskSecret), { name: "HMAC", hash: "SHA-256" }, false, ["sign"]);
+    const message = [method, path, timestamp, nonce, bodyHash].join("\n");
+    const signature = toHex(await crypto.subtle.sign("HMAC", key, encoder.encode(message)));
+
+    options.headers = {
+        ...options.headers,
+        "X-Kiosk-ID": kioskId,
+        "X-Kiosk-Timestamp": timestamp,
+        "X-Kiosk-Nonce": nonce,
+        "X-Kiosk-Signature": signature,
+    };
+
+    const response = await fetch(url, options);
+
+    // A revoked or mistyped kiosk is asked for its credentials again on the next request
+    if (response.status === 401) {
+        localStorage.removeItem("kioskId");
+        localStorage.removeItem("kioskSecret");
+    }
+
+    return response;
+}
+
+export default kioskFetch;
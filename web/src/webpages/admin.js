@@ -207,22 +207,56 @@ function Admin() {
           method: "POST",
         });
         
+        const data = await response.json();
         if (!response.ok) {
-            throw new Error(`HTTP error! status: ${response.status}`);
+            throw new Error(data.error || `HTTP error! status: ${response.status}`);
         }
-
-        const data = await response.json();
         setMessage(data.message);
 
+        // The backup is generated in the background; poll its job until it finishes
+        let job = data.job;
+        while (job.status !== "completed" && job.status !== "failed") {
+            await new Promise((resolve) => setTimeout(resolve, 1000));
+            const jobResponse = await fetchWithAuth(`${API_URL}/api/get-backup-job/${job.id}`);
+            if (!jobResponse.ok) {
+                throw new Error(`HTTP error! status: ${jobResponse.status}`);
+            }
+            job = await jobResponse.json();
+            setMessage(`Generating backup... ${job.sections_done}/${job.sections_total} sheets, ${job.rows_written} rows`);
+        }
+
+        if (job.status === "failed") {
+            throw new Error(job.error);
+        }
+        setMessage("Backup created successfully!");
+
         fetchBackupList(); // Refresh list after creating new backup
     } catch (error) {
         console.error("Failed to create backup:", error);
-        setMessage("Failed to create backup.");
+        setMessage(`Failed to create backup: ${error.message}`);
     } finally {
         setLoading(false);
     }
   };
 
+  // Backups stored before encryption was required are encrypted in place
+  const handleEncryptBackup = async (filename) => {
+    try {
+        const response = await fetchWithAuth(`${API_URL}/api/encrypt-backup/${encodeURIComponent(filename)}`, {
+          method: "POST",
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || `HTTP error! status: ${response.status}`);
+        }
+        setMessage(`${filename} encrypted.`);
+        fetchBackupList();
+    } catch (error) {
+        console.error("Failed to encrypt backup:", error);
+        setMessage(`Failed to encrypt ${filename}: ${error.message}`);
+    }
+  };
+
   const handleCreateVotesTallyPDF = async () => {
     const doc = new jsPDF();
 
@@ -502,6 +536,14 @@ function Admin() {
                                         >
                                             Download
                                         </a>
+                                        {!backup.encrypted && (
+                                            <>
+                                                {" "}- <strong>Not encrypted</strong>{" "}
+                                                <button onClick={() => handleEncryptBackup(backup.filename)}>
+                                                    Encrypt
+                                                </button>
+                                            </>
+                                        )}
                                     </li>
                                 ))}
                             </ul>
@@ -580,7 +622,7 @@ function Admin() {
                     <span className="custom-tooltip">
                     ⓘ
                         <span className="tooltip-content">
-                        Removes all data.
+                        Removes all data so a new election can be run. Certified canvass records are kept.
                         </span>
                     </span>
                     </h2>
@@ -593,7 +635,7 @@ function Admin() {
                 {showConfirmCard && (
                     <ConfirmCard
                         heading="Warning!"
-                        body="Election data will all be deleted. This includes: parties, positions, candidates, and voting timeframe. Make sure to backup the data if necessary."
+                        body="Election data will all be deleted to start a new election. This includes: parties, positions, candidates, voters, ballots, and voting timeframe. Signed canvass records of certified elections are kept. Make sure to backup the data if necessary."
                         onClose={handleCloseCard}
                         onConfirm={handleDeleteSubmit}
                     />
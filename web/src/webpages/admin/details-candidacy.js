@@ -1,6 +1,7 @@
 import React, { useEffect, useState } from "react";
 import "../../styles/admin/details-candidacy.css";
 import EditCandidateCredentialsModal from "../../components/edit-candidate-credentials-modal";
+import { credentialLabel } from "../../utils/credentials";
 
 const API_URL = process.env.REACT_APP_API_URL || "http://localhost:9000";
 
@@ -62,7 +63,7 @@ const DetailsCandidacy = () => {
               {candidate.credentials.length > 0 ? (
                 <ul className="candidate-credentials">
                   {candidate.credentials.map((cred, index) => (
-                    <li key={index}>{cred}</li>
+                    <li key={index}>{credentialLabel(cred)}</li>
                   ))}
                 </ul>
               ) : (
@@ -5,6 +5,46 @@ import fetchWithAuth from "../../utils/fetch-with-auth";
 
 const API_URL = process.env.REACT_APP_API_URL || 'http://localhost:9000';
 
+// Official program names, as the API matches them, offered for each board member seat
+const PROGRAMS_BY_POSITION = {
+  "BM (COE)": [
+    "Bachelor of Science in Computer Engineering",
+    "Bachelor of Science in Industrial Engineering",
+  ],
+  "BM (CBA)": [
+    "Bachelor of Science in Business Administration Major in Financial Management",
+    "Bachelor of Science in Business Administration Major in Marketing Management",
+    "Bachelor of Science in Entrepreneurship",
+  ],
+  "BM (BSIT)": ["Bachelor of Science in Information Technology"],
+  "BM (BIT)": [
+    "Bachelor of Industrial Technology Major in Automotive",
+    "Bachelor of Industrial Technology Major in Drafting and Digital Graphics",
+    "Bachelor of Industrial Technology Major in Computer",
+    "Bachelor of Industrial Technology Major in Electronics",
+    "Bachelor of Industrial Technology Major in Electrical",
+    "Bachelor of Industrial Technology Major in Food Processing",
+  ],
+  "BM (BSED)": [
+    "Bachelor of Secondary Education Major in Science",
+    "Bachelor of Secondary Education Major in Mathematics",
+    "Bachelor of Secondary Education Major in Social Studies",
+    "Bachelor of Secondary Education Major in English Minor in Mandarin",
+  ],
+  "BM (BEED)": [
+    "Bachelor of Elementary Education",
+    "Bachelor of Early Childhood Education",
+  ],
+  "BM (BPED-BTLED-BTVDED)": [
+    "Bachelor of Physical Education",
+    "Bachelor of Technical Vocational Teacher Education",
+    "Bachelor of Technology and Livelihood Education Major in Home Economics",
+  ],
+};
+
+// Governor and Vice Governor candidates may come from any program
+const ALL_PROGRAMS = Object.values(PROGRAMS_BY_POSITION).flat();
+
 function EditPreview({ onClose }) {
   const [partylistLeft, setPartylistLeft] = useState("");
   const [partylistRight, setPartylistRight] = useState("");
@@ -187,7 +227,13 @@ function EditPreview({ onClose }) {
     };
     
 
-  const renderCandidatesSection = (title, candidates, setCandidates) => (
+  const programOptions = (position, current) => {
+    const programs = PROGRAMS_BY_POSITION[position] ?? ALL_PROGRAMS;
+    // Keep a program saved before the list existed visible until it is replaced
+    return current && !programs.includes(current) ? [current, ...programs] : programs;
+  };
+
+  const renderCandidatesSection = (title, candidates, setCandidates, position) => (
     <>
       <h2 className="section-title">{title}</h2>
       <div className="candidates-section">
@@ -226,14 +272,19 @@ function EditPreview({ onClose }) {
               onChange={(e) => handleCandidateChange(candidates, setCandidates, index, "name", e.target.value)}
               className="input-field"
             />
-            <input
-              type="text"
-              placeholder="Enter program"
+            <select
               value={candidate.program}
               required
               onChange={(e) => handleCandidateChange(candidates, setCandidates, index, "program", e.target.value)}
               className="input-field"
-            />
+            >
+              <option value="">Select program</option>
+              {programOptions(position, candidate.program).map((program) => (
+                <option key={program} value={program}>
+                  {program}
+                </option>
+              ))}
+            </select>
             <input
               type="text"
               placeholder="Enter year level"
@@ -274,15 +325,15 @@ function EditPreview({ onClose }) {
           />
         </div>
 
-        {renderCandidatesSection("Governor Candidates", governorCandidates, setGovernorCandidates)}
-        {renderCandidatesSection("Vice Governor Candidates", viceGovernorCandidates, setViceGovernorCandidates)}
-        {renderCandidatesSection("BEED", beedCandidates, setBeedCandidates)}
-        {renderCandidatesSection("BPED-BTLED-BTVDED", bpedBtledBtvdedCandidates, setBpedBtledBtvdedCandidates)}
-        {renderCandidatesSection("BIT", bitCandidates, setBitCandidates)}
-        {renderCandidatesSection("BSIT", bsitCandidates, setBsitCandidates)}
-        {renderCandidatesSection("BSED", bsedCandidates, setBsedCandidates)}
-        {renderCandidatesSection("CBA", cbaCandidates, setCbaCandidates)}
-        {renderCandidatesSection("COE", coeCandidates, setCoeCandidates)}
+        {renderCandidatesSection("Governor Candidates", governorCandidates, setGovernorCandidates, "Governor")}
+        {renderCandidatesSection("Vice Governor Candidates", viceGovernorCandidates, setViceGovernorCandidates, "Vice Governor")}
+        {renderCandidatesSection("BEED", beedCandidates, setBeedCandidates, "BM (BEED)")}
+        {renderCandidatesSection("BPED-BTLED-BTVDED", bpedBtledBtvdedCandidates, setBpedBtledBtvdedCandidates, "BM (BPED-BTLED-BTVDED)")}
+        {renderCandidatesSection("BIT", bitCandidates, setBitCandidates, "BM (BIT)")}
+        {renderCandidatesSection("BSIT", bsitCandidates, setBsitCandidates, "BM (BSIT)")}
+        {renderCandidatesSection("BSED", bsedCandidates, setBsedCandidates, "BM (BSED)")}
+        {renderCandidatesSection("CBA", cbaCandidates, setCbaCandidates, "BM (CBA)")}
+        {renderCandidatesSection("COE", coeCandidates, setCoeCandidates, "BM (COE)")}
 
         <button className="save-button" onClick={handleSave}>
           Save
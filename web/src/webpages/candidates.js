@@ -1,5 +1,6 @@
 import { useState, useEffect } from "react";
 import "../styles/candidates.css";
+import { credentialLabel } from "../utils/credentials";
 
 const API_URL = process.env.REACT_APP_API_URL;
 
@@ -172,7 +173,7 @@ const CandidateCard = ({ candidate, viewMode }) => {
 
   return (
     <div className="render-candidate-card">
-      <img src={`/uploads/${candidate.photo_url}`} alt={candidate.name} className="candidate-photo" />
+      <img src={candidate.photo_src || `/uploads/${candidate.photo_url}`} alt={candidate.name} className="candidate-photo" />
       <h3 className="candidate-name">{candidate.name}</h3>
       <hr className="candidate-divider" />
 
@@ -190,7 +191,7 @@ const CandidateCard = ({ candidate, viewMode }) => {
           {candidate.credentials ? (
             <ul className="render-candidate-credentials">
               {Array.isArray(candidate.credentials)
-                ? candidate.credentials.map((cred, idx) => <li key={idx}>{cred}</li>)
+                ? candidate.credentials.map((cred, idx) => <li key={idx}>{credentialLabel(cred)}</li>)
                 : candidate.credentials.split(",").map((cred, idx) => <li key={idx}>{cred.trim()}</li>)}
             </ul>
           ) : (
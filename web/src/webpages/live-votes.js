@@ -54,51 +54,26 @@ const LiveVotes = () => {
   useEffect(() => {
     if (!activeTab) return;
 
-    const positionCandidates = candidates.filter((c) => c.position === activeTab);
-    if (positionCandidates.length < 2) return;
-
-    const positionNames = positionCandidates.map((c) => c.position_name);
-
-    Promise.all(
-      positionNames.map((posName) =>
-        fetch(`${API_URL}/api/get-total-votes/${posName}`, {
-          method: "GET",
-          headers: {"Ngrok-Skip-Browser-Warning": "true",}
-        })
-          .then((res) => res.json())
-          .then((data) => ({ [posName]: data.total_votes || 0 }))
-          .catch(() => ({ [posName]: 0 }))
-      )
-    ).then((results) => {
-      const votes = results.reduce((acc, curr) => ({ ...acc, ...curr }), {});
-      setTotalVotes(votes);
-    });
-  }, [activeTab, candidates]);
-
-  useEffect(() => {
-    if (!activeTab) return;
-
-    const positionCandidates = candidates.filter((c) => c.position === activeTab);
-    if (positionCandidates.length < 2) return;
-
-    const positionNames = positionCandidates.map((c) => c.position_name);
-
-    Promise.all(
-      positionNames.flatMap((posName) =>
-        departmentKeys.map((dept) =>
-          fetch(`${API_URL}/api/get-department-votes/${posName}/${dept}`, {
-            method: "GET",
-            headers: {"Ngrok-Skip-Browser-Warning": "true",}
-          })
-            .then((res) => res.json())
-            .then((data) => ({ [`${posName}_${dept}`]: data.total_votes || 0 }))
-            .catch(() => ({ [`${posName}_${dept}`]: 0 }))
-        )
-      )
-    ).then((results) => {
-      const deptVotes = results.reduce((acc, curr) => ({ ...acc, ...curr }), {});
-      setDepartmentVotes(deptVotes);
-    });
+    fetch(`${API_URL}/api/get-results`, {
+      method: "GET",
+      headers: {"Ngrok-Skip-Browser-Warning": "true",}
+    })
+      .then((res) => res.json())
+      .then((data) => {
+        const votes = {};
+        const deptVotes = {};
+        (data.positions || []).forEach((position) => {
+          position.candidates.forEach((candidate) => {
+            votes[candidate.position_name] = candidate.total_votes;
+            departmentKeys.forEach((dept) => {
+              deptVotes[`${candidate.position_name}_${dept}`] = candidate.departments[dept] || 0;
+            });
+          });
+        });
+        setTotalVotes(votes);
+        setDepartmentVotes(deptVotes);
+      })
+      .catch((err) => console.error("Failed to fetch results:", err));
   }, [activeTab, candidates]);
 
   const getVotesForCandidate = (candidate) => {